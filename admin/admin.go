@@ -0,0 +1,42 @@
+package admin
+
+import (
+	"net/http"
+
+	"go_project/config"
+	"go_project/logging"
+)
+
+var cfg config.Admin
+
+// APIToken が空なら API、Password が空なら管理画面を無効にする
+func Configure(c config.Admin) {
+	cfg = c
+	sessionKey = newSessionKey(c.SessionSecret.Value())
+}
+
+// /admin/ 以下のハンドラ
+//
+//	/admin/api/... ADMIN_API_TOKEN による REST API
+//	/admin/...     ADMIN_PASSWORD でログインする管理画面
+func Handler() http.Handler {
+	api := http.NewServeMux()
+	apiRoutes(api)
+
+	dashboard := http.NewServeMux()
+	dashboardRoutes(dashboard)
+
+	mux := http.NewServeMux()
+	mux.Handle("/admin/api/", requireToken(api))
+	mux.Handle("/admin/", requirePassword(dashboard))
+	return withCorrelationID(mux)
+}
+
+// リクエストごとに相関IDを付ける（X-Request-ID があれば引き継ぐ）
+func withCorrelationID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := logging.NewContext(r.Context(), r.Header.Get("X-Request-ID"))
+		w.Header().Set("X-Request-ID", logging.CorrelationID(ctx))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
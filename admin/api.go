@@ -0,0 +1,353 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	azure "go_project/azurefolder"
+	"go_project/logging"
+	"go_project/supabase"
+)
+
+/* =======================
+   管理用 REST API（/admin/api/...）
+======================= */
+
+// ダウンロードリンクの有効期間（分）
+const downloadLinkMinutes = 60
+
+func apiRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /admin/api/users", listUsers)
+	mux.HandleFunc("GET /admin/api/users/{lineUserID}", getUser)
+	mux.HandleFunc("POST /admin/api/users/{lineUserID}/revoke", revokeUser)
+	mux.HandleFunc("POST /admin/api/users/{lineUserID}/restore", restoreUser)
+
+	mux.HandleFunc("GET /admin/api/codes", listCodes)
+	mux.HandleFunc("POST /admin/api/codes", issueCodes)
+	mux.HandleFunc("POST /admin/api/codes/revoke", revokeCodes)
+
+	mux.HandleFunc("GET /admin/api/jobs", listJobs)
+	mux.HandleFunc("GET /admin/api/usage", usage)
+	mux.HandleFunc("GET /admin/api/security-events", listSecurityEvents)
+}
+
+// Authorization: Bearer <ADMIN_API_TOKEN>
+func requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.APIToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.APIToken.Value())) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+/* ---------- ユーザー ---------- */
+
+func listUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	users, err := supabase.ListUsers(r.Context(), supabase.UserFilter{
+		Search: q.Get("q"),
+		Status: supabase.UserStatus(q.Get("status")),
+		Plan:   q.Get("plan"),
+		Limit:  limitParam(q.Get("limit"), 50, maxLimit),
+		Offset: intParam(q.Get("offset"), 0),
+	})
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+
+	now := time.Now()
+	out := make([]userView, len(users))
+	for i := range users {
+		out[i] = newUserView(&users[i], now)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func getUser(w http.ResponseWriter, r *http.Request) {
+	user, err := supabase.GetUserByLineID(r.Context(), r.PathValue("lineUserID"))
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	if user == nil {
+		writeError(w, http.StatusNotFound, errors.New("user not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, newUserView(user, time.Now()))
+}
+
+func revokeUser(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	user, err := supabase.RevokeUser(r.Context(), r.PathValue("lineUserID"), body.Reason)
+	writeUserResult(w, user, err)
+}
+
+func restoreUser(w http.ResponseWriter, r *http.Request) {
+	user, err := supabase.RestoreUser(r.Context(), r.PathValue("lineUserID"))
+	writeUserResult(w, user, err)
+}
+
+func writeUserResult(w http.ResponseWriter, user *supabase.User, err error) {
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	if user == nil {
+		writeError(w, http.StatusNotFound, errors.New("user not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, newUserView(user, time.Now()))
+}
+
+type userView struct {
+	supabase.User
+	Status supabase.UserStatus `json:"status"`
+}
+
+func newUserView(u *supabase.User, now time.Time) userView {
+	return userView{User: *u, Status: u.Status(now)}
+}
+
+/* ---------- 認証コード ---------- */
+
+func listCodes(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	codes, err := supabase.ListAuthCodes(r.Context(), supabase.AuthCodeFilter{
+		Batch:  q.Get("batch"),
+		Status: q.Get("status"),
+		Limit:  limitParam(q.Get("limit"), 200, maxLimit),
+	})
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, codes)
+}
+
+func issueCodes(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Count     int        `json:"count"`
+		Length    int        `json:"length"`
+		Batch     string     `json:"batch"`
+		Plan      string     `json:"plan"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if body.Count > 1000 {
+		writeError(w, http.StatusBadRequest, errors.New("count must be 1000 or less"))
+		return
+	}
+
+	codes, err := supabase.IssueAuthCodes(r.Context(), supabase.IssueOptions{
+		Count:     body.Count,
+		Length:    body.Length,
+		Batch:     body.Batch,
+		Plan:      body.Plan,
+		ExpiresAt: body.ExpiresAt,
+	})
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, codes)
+}
+
+func revokeCodes(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Batch string   `json:"batch"`
+		Codes []string `json:"codes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	revoked, err := supabase.RevokeAuthCodes(r.Context(), body.Batch, body.Codes)
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, revoked)
+}
+
+/* ---------- 生成ジョブ ---------- */
+
+type jobView struct {
+	supabase.Job
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+func listJobs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	jobs, err := supabase.ListJobs(r.Context(), supabase.JobFilter{
+		LineUserID: q.Get("user"),
+		Status:     q.Get("status"),
+		Limit:      limitParam(q.Get("limit"), 50, maxLimit),
+		Offset:     intParam(q.Get("offset"), 0),
+	})
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+
+	out := make([]jobView, len(jobs))
+	for i, j := range jobs {
+		out[i] = jobView{Job: j, DownloadURL: downloadURL(r.Context(), j)}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func downloadURL(ctx context.Context, j supabase.Job) string {
+	if j.Status != supabase.JobSucceeded || j.Container == nil || j.BlobName == nil {
+		return ""
+	}
+	url, err := azure.GenerateBlobSASURL(ctx, *j.Container, *j.BlobName, downloadLinkMinutes)
+	if err != nil {
+		slog.ErrorContext(ctx, "admin: SAS生成失敗", "job_id", j.ID, logging.Err(err))
+		return ""
+	}
+	return url
+}
+
+/* ---------- 利用状況 ---------- */
+
+type usageTotal struct {
+	Key         string `json:"key"`
+	Generations int    `json:"generations"`
+	Chats       int    `json:"chats"`
+}
+
+// ?from=YYYY-MM-DD&to=YYYY-MM-DD&user=... （既定は直近30日）
+func usage(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	// usage_daily.day は日本時間の日付
+	to := time.Now().In(jst)
+	from := to.AddDate(0, 0, -29)
+	var err error
+	if v := q.Get("from"); v != "" {
+		if from, err = time.ParseInLocation("2006-01-02", v, jst); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if to, err = time.ParseInLocation("2006-01-02", v, jst); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	days, err := supabase.ListUsageDaily(r.Context(), q.Get("user"), from, to)
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"from":    from.Format("2006-01-02"),
+		"to":      to.Format("2006-01-02"),
+		"rows":    days,
+		"by_day":  totals(days, func(d supabase.UsageDay) string { return d.Day }),
+		"by_user": totals(days, func(d supabase.UsageDay) string { return d.LineUserID }),
+	})
+}
+
+func totals(days []supabase.UsageDay, key func(supabase.UsageDay) string) []usageTotal {
+	m := map[string]*usageTotal{}
+	for _, d := range days {
+		k := key(d)
+		t := m[k]
+		if t == nil {
+			t = &usageTotal{Key: k}
+			m[k] = t
+		}
+		t.Generations += d.Generations
+		t.Chats += d.Chats
+	}
+
+	out := make([]usageTotal, 0, len(m))
+	for _, t := range m {
+		out = append(out, *t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+/* ---------- 不審な操作 ---------- */
+
+func listSecurityEvents(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	events, err := supabase.ListSecurityEvents(r.Context(), q.Get("user"), limitParam(q.Get("limit"), 100, maxLimit))
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+/* ---------- 共通 ---------- */
+
+func intParam(s string, def int) int {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return def
+	}
+	return n
+}
+
+// 一覧の件数の上限（0 を渡すと Supabase 側で無制限になるので 1〜upper に収める）
+const maxLimit = 1000
+
+func limitParam(s string, def, upper int) int {
+	return min(max(1, intParam(s, def)), upper)
+}
+
+// 入力不正は 400、Supabase との通信エラー等は 502
+func statusFor(err error) int {
+	var inputErr supabase.InputError
+	if errors.As(err, &inputErr) {
+		return http.StatusBadRequest
+	}
+	return http.StatusBadGateway
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("admin: レスポンス書き込み失敗", logging.Err(err))
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
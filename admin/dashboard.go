@@ -0,0 +1,464 @@
+package admin
+
+import (
+	"embed"
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go_project/authguard"
+	"go_project/logging"
+	"go_project/quota"
+	"go_project/supabase"
+)
+
+/* =======================
+   管理画面（/admin/）
+======================= */
+
+//go:embed templates/*.html static/*
+var files embed.FS
+
+var jst = time.FixedZone("JST", 9*60*60)
+
+var funcs = template.FuncMap{
+	"datetime": datetime,
+	"deref":    deref,
+	"limit": func(n *int) string {
+		if n == nil {
+			return "∞"
+		}
+		return strconv.Itoa(*n)
+	},
+	"elapsed": func(j supabase.Job) string {
+		if j.FinishedAt == nil {
+			return ""
+		}
+		return j.FinishedAt.Sub(j.CreatedAt).Round(time.Second).String()
+	},
+}
+
+func datetime(t any) string {
+	switch v := t.(type) {
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.In(jst).Format("2006/01/02 15:04")
+	case *time.Time:
+		if v == nil {
+			return ""
+		}
+		return v.In(jst).Format("2006/01/02 15:04")
+	}
+	return ""
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+var pages = map[string]*template.Template{}
+
+func init() {
+	for _, name := range []string{"login", "dashboard", "users", "codes"} {
+		pages[name] = template.Must(
+			template.New("layout.html").Funcs(funcs).ParseFS(files, "templates/layout.html", "templates/"+name+".html"),
+		)
+	}
+}
+
+func dashboardRoutes(mux *http.ServeMux) {
+	static, _ := fs.Sub(files, "static")
+	mux.Handle("GET /admin/static/", http.StripPrefix("/admin/static/", http.FileServerFS(static)))
+
+	mux.HandleFunc("GET /admin/login", loginPage)
+	mux.HandleFunc("POST /admin/login", login)
+	mux.HandleFunc("POST /admin/logout", logout)
+
+	mux.HandleFunc("GET /admin/{$}", requireLogin(dashboardPage))
+	mux.HandleFunc("GET /admin/users", requireLogin(usersPage))
+	mux.HandleFunc("POST /admin/users/{lineUserID}/revoke", requireLogin(revokeUserForm))
+	mux.HandleFunc("POST /admin/users/{lineUserID}/restore", requireLogin(restoreUserForm))
+	mux.HandleFunc("GET /admin/codes", requireLogin(codesPage))
+	mux.HandleFunc("POST /admin/codes", requireLogin(issueCodesForm))
+	mux.HandleFunc("POST /admin/codes/revoke", requireLogin(revokeCodesForm))
+	mux.HandleFunc("GET /admin/codes.csv", requireLogin(codesCSV))
+}
+
+// 管理画面が無効なら 404
+func requirePassword(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.Password == "" {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type pageData struct {
+	Title string
+	CSRF  string
+	Flash string
+	Error string
+	Data  any
+}
+
+func render(w http.ResponseWriter, r *http.Request, name, title string, data any, pageErr error) {
+	pd := pageData{Title: title, Data: data, Flash: r.URL.Query().Get("flash")}
+	if sess, ok := session(r); ok {
+		pd.CSRF = csrfToken(sess)
+	}
+	if pageErr != nil {
+		slog.WarnContext(r.Context(), "admin: 画面処理エラー", "page", name, logging.Err(pageErr))
+		pd.Error = pageErr.Error()
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := pages[name].Execute(w, pd); err != nil {
+		slog.ErrorContext(r.Context(), "admin: テンプレート描画失敗", "page", name, logging.Err(err))
+	}
+}
+
+func redirectFlash(w http.ResponseWriter, r *http.Request, path, flash string) {
+	http.Redirect(w, r, path+"?flash="+url.QueryEscape(flash), http.StatusSeeOther)
+}
+
+/* ---------- ログイン ---------- */
+
+func loginPage(w http.ResponseWriter, r *http.Request) {
+	render(w, r, "login", "ログイン", nil, nil)
+}
+
+// 総当たり対策は認証コードと同じ仕組みを接続元IPごとに使う。
+// 失敗履歴・全体のレートは認証コードとは別に数える。
+var loginGuard = authguard.New(authguard.Events{
+	Lockout:       supabase.EventAdminLockout,
+	LockedAttempt: supabase.EventAdminLockedAttempt,
+	GlobalLimited: supabase.EventAdminGlobalLimited,
+}, 0.5, 10, "ip")
+
+func login(w http.ResponseWriter, r *http.Request) {
+	ip := clientIP(r)
+	if d := loginGuard.Allow(r.Context(), ip); !d.Allowed {
+		w.WriteHeader(http.StatusTooManyRequests)
+		render(w, r, "login", "ログイン", nil, fmt.Errorf("試行回数が多すぎます。%s後に再度お試しください", d.RetryAfter.Round(time.Second)))
+		return
+	}
+
+	if !checkPassword(r.PostFormValue("password")) {
+		loginGuard.Failure(r.Context(), ip)
+		w.WriteHeader(http.StatusUnauthorized)
+		render(w, r, "login", "ログイン", nil, fmt.Errorf("パスワードが違います"))
+		return
+	}
+
+	loginGuard.Success(ip)
+	newSession(w, r)
+	http.Redirect(w, r, "/admin/", http.StatusSeeOther)
+}
+
+// 接続元IP。リバースプロキシの後ろでは全員が同じ RemoteAddr になるので、
+// ADMIN_TRUST_PROXY のときはプロキシが付け足した X-Forwarded-For の最後の値を使う。
+func clientIP(r *http.Request) string {
+	if cfg.TrustProxy {
+		if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+			hops := strings.Split(xff[len(xff)-1], ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+func logout(w http.ResponseWriter, r *http.Request) {
+	clearSession(w)
+	http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+}
+
+/* ---------- ダッシュボード ---------- */
+
+type quotaRow struct {
+	LineUserID       string
+	Plan             string
+	Generations      int
+	GenerationsQuota *int
+	Chats            int
+	ChatsQuota       *int
+}
+
+type dashboardData struct {
+	ActiveUsers  int
+	ExpiredUsers int
+	RevokedUsers int
+	PeriodStart  time.Time
+	Jobs         []supabase.Job
+	FailedJobs   int
+	Quota        []quotaRow
+}
+
+func dashboardPage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var d dashboardData
+	var err error
+
+	if d.ActiveUsers, err = supabase.CountUsers(ctx, supabase.UserFilter{Status: supabase.UserActive}); err != nil {
+		render(w, r, "dashboard", "ダッシュボード", d, err)
+		return
+	}
+	if d.ExpiredUsers, err = supabase.CountUsers(ctx, supabase.UserFilter{Status: supabase.UserExpired}); err != nil {
+		render(w, r, "dashboard", "ダッシュボード", d, err)
+		return
+	}
+	if d.RevokedUsers, err = supabase.CountUsers(ctx, supabase.UserFilter{Status: supabase.UserRevoked}); err != nil {
+		render(w, r, "dashboard", "ダッシュボード", d, err)
+		return
+	}
+
+	if d.Jobs, err = supabase.ListJobs(ctx, supabase.JobFilter{Limit: 20}); err != nil {
+		render(w, r, "dashboard", "ダッシュボード", d, err)
+		return
+	}
+	for _, j := range d.Jobs {
+		if j.Status == supabase.JobFailed {
+			d.FailedJobs++
+		}
+	}
+
+	now := time.Now().In(jst) // usage_daily.day は日本時間の日付
+	d.PeriodStart = quota.PeriodStart(now)
+	d.Quota, err = quotaRows(r, d.PeriodStart, now)
+	render(w, r, "dashboard", "ダッシュボード", d, err)
+}
+
+// 今期の利用回数が多いユーザー上位20件とプランの上限
+func quotaRows(r *http.Request, from, to time.Time) ([]quotaRow, error) {
+	ctx := r.Context()
+	days, err := supabase.ListUsageDaily(ctx, "", from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	byUser := map[string]*quotaRow{}
+	var ids []string
+	for _, d := range days {
+		row := byUser[d.LineUserID]
+		if row == nil {
+			row = &quotaRow{LineUserID: d.LineUserID}
+			byUser[d.LineUserID] = row
+			ids = append(ids, d.LineUserID)
+		}
+		row.Generations += d.Generations
+		row.Chats += d.Chats
+	}
+
+	rows := make([]quotaRow, 0, len(byUser))
+	for _, row := range byUser {
+		rows = append(rows, *row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Generations != rows[j].Generations {
+			return rows[i].Generations > rows[j].Generations
+		}
+		return rows[i].Chats > rows[j].Chats
+	})
+	if len(rows) > 20 {
+		rows = rows[:20]
+	}
+
+	ids = ids[:0]
+	for _, row := range rows {
+		ids = append(ids, row.LineUserID)
+	}
+	users, err := supabase.GetUsersByLineIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	plans, err := supabase.ListPlans(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	planOf := map[string]string{}
+	for _, u := range users {
+		planOf[u.LineUserID] = u.Plan
+	}
+	planByName := map[string]supabase.Plan{}
+	for _, p := range plans {
+		planByName[p.Name] = p
+	}
+	for i := range rows {
+		rows[i].Plan = planOf[rows[i].LineUserID]
+		p := planByName[rows[i].Plan]
+		rows[i].GenerationsQuota = p.MonthlyGenerations
+		rows[i].ChatsQuota = p.MonthlyChats
+	}
+	return rows, nil
+}
+
+/* ---------- ユーザー ---------- */
+
+type usersData struct {
+	Query  string
+	Status string
+	Users  []userView
+}
+
+func usersPage(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	d := usersData{Query: q.Get("q"), Status: q.Get("status")}
+
+	users, err := supabase.ListUsers(r.Context(), supabase.UserFilter{
+		Search: d.Query,
+		Status: supabase.UserStatus(d.Status),
+		Limit:  100,
+	})
+	now := time.Now()
+	for i := range users {
+		d.Users = append(d.Users, newUserView(&users[i], now))
+	}
+	render(w, r, "users", "ユーザー", d, err)
+}
+
+func revokeUserForm(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("lineUserID")
+	if _, err := supabase.RevokeUser(r.Context(), id, r.PostFormValue("reason")); err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+	redirectFlash(w, r, "/admin/users", id+" を利用停止しました")
+}
+
+func restoreUserForm(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("lineUserID")
+	if _, err := supabase.RestoreUser(r.Context(), id); err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+	redirectFlash(w, r, "/admin/users", id+" の利用停止を解除しました")
+}
+
+/* ---------- 認証コード ---------- */
+
+type codesData struct {
+	Batch  string
+	Status string
+	Plans  []string
+	Issued []supabase.AuthCode
+	Codes  []supabase.AuthCode
+}
+
+func codesPage(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	d := codesData{
+		Batch:  q.Get("batch"),
+		Status: q.Get("status"),
+		Plans:  []string{supabase.PlanStandard, supabase.PlanPro, supabase.PlanFree},
+	}
+	var err error
+	d.Codes, err = supabase.ListAuthCodes(r.Context(), supabase.AuthCodeFilter{
+		Batch:  d.Batch,
+		Status: d.Status,
+		Limit:  200,
+	})
+	render(w, r, "codes", "認証コード", d, err)
+}
+
+func issueCodesForm(w http.ResponseWriter, r *http.Request) {
+	d := codesData{
+		Batch: r.PostFormValue("batch"),
+		Plans: []string{supabase.PlanStandard, supabase.PlanPro, supabase.PlanFree},
+	}
+
+	count, _ := strconv.Atoi(r.PostFormValue("count"))
+	if count > 1000 {
+		render(w, r, "codes", "認証コード", d, fmt.Errorf("一度に発行できるのは1000件までです"))
+		return
+	}
+	opts := supabase.IssueOptions{
+		Count: count,
+		Batch: d.Batch,
+		Plan:  r.PostFormValue("plan"),
+	}
+	if v := r.PostFormValue("expires"); v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, jst)
+		if err != nil {
+			render(w, r, "codes", "認証コード", d, fmt.Errorf("有効期限の形式が不正です"))
+			return
+		}
+		t = t.AddDate(0, 0, 1).Add(-time.Second)
+		opts.ExpiresAt = &t
+	}
+
+	var err error
+	d.Issued, err = supabase.IssueAuthCodes(r.Context(), opts)
+	render(w, r, "codes", "認証コード", d, err)
+}
+
+func revokeCodesForm(w http.ResponseWriter, r *http.Request) {
+	batch := r.PostFormValue("batch")
+	var codes []string
+	for _, c := range strings.Fields(r.PostFormValue("codes")) {
+		codes = append(codes, strings.ToUpper(c))
+	}
+
+	revoked, err := supabase.RevokeAuthCodes(r.Context(), batch, codes)
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+	redirectFlash(w, r, "/admin/codes", fmt.Sprintf("%d件を失効させました", len(revoked)))
+}
+
+// 配布用CSV
+func codesCSV(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	codes, err := supabase.ListAuthCodes(r.Context(), supabase.AuthCodeFilter{
+		Batch:  q.Get("batch"),
+		Status: q.Get("status"),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), statusFor(err))
+		return
+	}
+
+	name := "auth_codes.csv"
+	if b := q.Get("batch"); b != "" {
+		name = "auth_codes_" + b + ".csv"
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"code", "batch", "plan", "expires_at", "used", "used_by", "used_at"})
+	for _, c := range codes {
+		cw.Write([]string{
+			c.Code,
+			c.Batch,
+			c.Plan,
+			datetime(c.ExpiresAt),
+			strconv.FormatBool(c.Used),
+			deref(c.UsedBy),
+			datetime(c.UsedAt),
+		})
+	}
+	cw.Flush()
+}
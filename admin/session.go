@@ -0,0 +1,125 @@
+package admin
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* =======================
+   管理画面のログイン
+======================= */
+
+const (
+	sessionCookie = "admin_session"
+	sessionTTL    = 12 * time.Hour
+)
+
+var sessionKey = newSessionKey("")
+
+// 署名鍵は SessionSecret から作る。未設定なら起動ごとに生成する（再起動でログアウトされる）
+func newSessionKey(secret string) []byte {
+	if secret != "" {
+		sum := sha256.Sum256([]byte(secret))
+		return sum[:]
+	}
+	b := make([]byte, 32)
+	rand.Read(b)
+	return b
+}
+
+func sign(s string) string {
+	mac := hmac.New(sha256.New, sessionKey)
+	mac.Write([]byte(s))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// 長さの違いが漏れないようハッシュ同士で比べる
+func checkPassword(password string) bool {
+	if cfg.Password == "" {
+		return false
+	}
+	a := sha256.Sum256([]byte(password))
+	b := sha256.Sum256([]byte(cfg.Password.Value()))
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
+// セッション値は「有効期限.署名」
+func newSession(w http.ResponseWriter, r *http.Request) {
+	expires := time.Now().Add(sessionTTL)
+	payload := strconv.FormatInt(expires.Unix(), 10)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    payload + "." + sign(payload),
+		Path:     "/admin",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func clearSession(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/admin",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// 有効なセッションならその値を返す
+func session(r *http.Request) (string, bool) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", false
+	}
+	payload, sig, ok := strings.Cut(c.Value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(sign(payload))) {
+		return "", false
+	}
+	exp, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return "", false
+	}
+	return c.Value, true
+}
+
+// フォーム送信用のCSRFトークン（セッションごとに固定）
+func csrfToken(sessionValue string) string {
+	mac := hmac.New(sha256.New, sessionKey)
+	mac.Write([]byte("csrf|" + sessionValue))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ログイン必須。POST はCSRFトークンも確認する。
+func requireLogin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sess, ok := session(r)
+		if !ok {
+			if r.Method == http.MethodGet {
+				http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+			} else {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+			}
+			return
+		}
+		if r.Method == http.MethodPost {
+			token := r.PostFormValue("csrf")
+			if !hmac.Equal([]byte(token), []byte(csrfToken(sess))) {
+				http.Error(w, "invalid csrf token", http.StatusForbidden)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
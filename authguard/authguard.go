@@ -0,0 +1,256 @@
+package authguard
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"go_project/logging"
+	"go_project/supabase"
+)
+
+/* =======================
+   認証の試行の制限
+======================= */
+
+var (
+	// ロックせずに許す連続失敗回数
+	FreeAttempts = 5
+	// 最初のロック時間（以降は失敗ごとに倍）
+	BaseLockout = time.Minute
+	MaxLockout  = 24 * time.Hour
+	// 最後の失敗からこれだけ経てば失敗回数をリセット
+	FailureWindow = 24 * time.Hour
+
+	// 全ユーザー合計の試行レート（トークンバケット）
+	GlobalRate  = 2.0 // 回/秒
+	GlobalBurst = 30.0
+)
+
+// 現在時刻（テストで差し替える）
+var clock = time.Now
+
+// Allow の判定結果
+type Decision struct {
+	Allowed    bool
+	RetryAfter time.Duration
+}
+
+// 記録する security event の種類
+type Events struct {
+	Lockout       string // 失敗が続きロックした
+	LockedAttempt string // ロック中に試行した
+	GlobalLimited string // 全体のレート制限に達した
+}
+
+// Guard は 1 種類の認証の試行制限。失敗履歴と全体のトークンバケットは Guard ごとに持つので、
+// 管理画面のログインの失敗が認証コードの受付を止めることはない。
+type Guard struct {
+	events Events
+	// 全体の試行レート（0 ならパッケージの GlobalRate/GlobalBurst）
+	rate, burst float64
+	// 空なら key は LINE ユーザーID。そうでなければ key を detail のこの名前で記録する
+	keyName string
+
+	mu    sync.Mutex
+	users map[string]*attempt
+
+	tokens     float64
+	lastRefill time.Time
+	// 全体制限の記録は1分に1回まで
+	lastGlobalReport time.Time
+}
+
+type attempt struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+	reported    bool // 現在のロック中の試行を記録済みか
+}
+
+// LINE の認証コード用（key は LINE ユーザーID）
+var codes = &Guard{
+	events: Events{
+		Lockout:       supabase.EventLockout,
+		LockedAttempt: supabase.EventLockedAttempt,
+		GlobalLimited: supabase.EventGlobalLimited,
+	},
+}
+
+// New は認証コードとは別に数える Guard を作る。
+// key は security_events の detail に keyName として記録する（line_user_id は空）。
+func New(events Events, rate, burst float64, keyName string) *Guard {
+	return &Guard{events: events, rate: rate, burst: burst, keyName: keyName}
+}
+
+// 認証コードを試行してよいか判定する。
+func Allow(ctx context.Context, lineUserID string) Decision {
+	return codes.Allow(ctx, lineUserID)
+}
+
+// 認証コードの失敗を記録する。ロックした場合はその時間を返す。
+func Failure(ctx context.Context, lineUserID string) time.Duration {
+	return codes.Failure(ctx, lineUserID)
+}
+
+// 認証コードに成功したら失敗履歴を消す
+func Success(lineUserID string) {
+	codes.Success(lineUserID)
+}
+
+// 試行してよいか判定する。許可した場合は全体のトークンを1つ消費する。
+func (g *Guard) Allow(ctx context.Context, key string) Decision {
+	now := clock()
+
+	g.mu.Lock()
+	a := g.current(key, now)
+	if a != nil && now.Before(a.lockedUntil) {
+		retry := a.lockedUntil.Sub(now)
+		report := !a.reported
+		a.reported = true
+		g.mu.Unlock()
+
+		if report {
+			g.record(ctx, key, g.events.LockedAttempt, map[string]any{"retry_after_sec": int(retry.Seconds())})
+		}
+		return Decision{RetryAfter: retry}
+	}
+
+	g.refill(now)
+	if g.tokens < 1 {
+		retry := time.Duration((1 - g.tokens) / g.rateLimit() * float64(time.Second))
+		report := now.Sub(g.lastGlobalReport) >= time.Minute
+		if report {
+			g.lastGlobalReport = now
+		}
+		g.mu.Unlock()
+
+		if report {
+			g.record(ctx, key, g.events.GlobalLimited, nil)
+		}
+		return Decision{RetryAfter: retry}
+	}
+	g.tokens--
+	g.mu.Unlock()
+
+	return Decision{Allowed: true}
+}
+
+// 失敗を記録する。ロックした場合はその時間を返す。
+func (g *Guard) Failure(ctx context.Context, key string) time.Duration {
+	now := clock()
+
+	g.mu.Lock()
+	if g.users == nil {
+		g.users = map[string]*attempt{}
+	}
+	a := g.current(key, now)
+	if a == nil {
+		if len(g.users) >= pruneThreshold {
+			g.prune(now)
+		}
+		a = &attempt{}
+		g.users[key] = a
+	}
+	a.failures++
+	a.lastFailure = now
+
+	lock := lockoutFor(a.failures)
+	if lock > 0 {
+		a.lockedUntil = now.Add(lock)
+		a.reported = false
+	}
+	failures := a.failures
+	g.mu.Unlock()
+
+	if lock > 0 {
+		g.record(ctx, key, g.events.Lockout, map[string]any{
+			"failures":    failures,
+			"lockout_sec": int(lock.Seconds()),
+		})
+	}
+	return lock
+}
+
+// 成功したら失敗履歴を消す
+func (g *Guard) Success(key string) {
+	g.mu.Lock()
+	delete(g.users, key)
+	g.mu.Unlock()
+}
+
+// FreeAttempts 回を超えた失敗から BaseLockout を倍々にしていく
+func lockoutFor(failures int) time.Duration {
+	over := failures - FreeAttempts
+	if over <= 0 {
+		return 0
+	}
+	lock := time.Duration(float64(BaseLockout) * math.Pow(2, float64(over-1)))
+	if lock <= 0 || lock > MaxLockout {
+		return MaxLockout
+	}
+	return lock
+}
+
+// 期限切れの履歴は捨てる（g.mu を保持して呼ぶ）
+func (g *Guard) current(key string, now time.Time) *attempt {
+	a := g.users[key]
+	if a == nil {
+		return nil
+	}
+	if now.After(a.lockedUntil) && now.Sub(a.lastFailure) > FailureWindow {
+		delete(g.users, key)
+		return nil
+	}
+	return a
+}
+
+// 履歴がこれ以上溜まったら期限切れをまとめて捨てる
+const pruneThreshold = 10000
+
+// g.mu を保持して呼ぶ
+func (g *Guard) prune(now time.Time) {
+	for key := range g.users {
+		g.current(key, now)
+	}
+}
+
+func (g *Guard) rateLimit() float64 {
+	if g.rate > 0 {
+		return g.rate
+	}
+	return GlobalRate
+}
+
+func (g *Guard) burstLimit() float64 {
+	if g.burst > 0 {
+		return g.burst
+	}
+	return GlobalBurst
+}
+
+// g.mu を保持して呼ぶ
+func (g *Guard) refill(now time.Time) {
+	if g.lastRefill.IsZero() {
+		g.tokens = g.burstLimit()
+	} else {
+		g.tokens = min(g.burstLimit(), g.tokens+now.Sub(g.lastRefill).Seconds()*g.rateLimit())
+	}
+	g.lastRefill = now
+}
+
+func (g *Guard) record(ctx context.Context, key, kind string, detail map[string]any) {
+	ev := supabase.SecurityEvent{LineUserID: key, Kind: kind, Detail: detail}
+	if g.keyName != "" {
+		if ev.Detail == nil {
+			ev.Detail = map[string]any{}
+		}
+		ev.LineUserID, ev.Detail[g.keyName] = "", key
+	}
+	slog.WarnContext(ctx, "security event", "kind", ev.Kind, logging.User(ev.LineUserID), "detail", ev.Detail)
+	if err := supabase.AddSecurityEvent(ctx, ev); err != nil {
+		slog.ErrorContext(ctx, "security event 記録失敗", logging.Err(err))
+	}
+}
@@ -0,0 +1,179 @@
+package authguard
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"go_project/config"
+	"go_project/supabase"
+)
+
+var testEvents = Events{Lockout: "lockout", LockedAttempt: "locked_attempt", GlobalLimited: "global_limited"}
+
+// 時計を止め、記録した security event の種類を返す Supabase に向ける
+func setup(t *testing.T) (advance func(time.Duration), events func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var kinds []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var row struct{ Kind string }
+		json.NewDecoder(r.Body).Decode(&row)
+		mu.Lock()
+		kinds = append(kinds, row.Kind)
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	supabase.Configure(config.Supabase{URL: srv.URL, ServiceRoleKey: "test"})
+
+	now := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	clock = func() time.Time { return now }
+	t.Cleanup(func() {
+		srv.Close()
+		supabase.Configure(config.Supabase{})
+		clock = time.Now
+	})
+	return func(d time.Duration) { now = now.Add(d) }, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), kinds...)
+	}
+}
+
+func TestLockoutFor(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{FreeAttempts, 0},
+		{FreeAttempts + 1, BaseLockout},
+		{FreeAttempts + 2, 2 * BaseLockout},
+		{FreeAttempts + 4, 8 * BaseLockout},
+		{FreeAttempts + 11, 1024 * time.Minute},
+		{FreeAttempts + 12, MaxLockout}, // 2048 分は上限を超える
+		{FreeAttempts + 1000, MaxLockout},
+	}
+	for _, tt := range tests {
+		if got := lockoutFor(tt.failures); got != tt.want {
+			t.Errorf("lockoutFor(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestFailureLockout(t *testing.T) {
+	advance, events := setup(t)
+	ctx := context.Background()
+	g := New(testEvents, 100, 100, "")
+
+	for i := 0; i < FreeAttempts; i++ {
+		if lock := g.Failure(ctx, "U1"); lock != 0 {
+			t.Fatalf("%d 回目の失敗でロックしました: %v", i+1, lock)
+		}
+	}
+	if d := g.Allow(ctx, "U1"); !d.Allowed {
+		t.Fatal("ロック前に拒否しました")
+	}
+
+	if lock := g.Failure(ctx, "U1"); lock != BaseLockout {
+		t.Fatalf("ロック = %v, want %v", lock, BaseLockout)
+	}
+	for i := 0; i < 2; i++ {
+		if d := g.Allow(ctx, "U1"); d.Allowed || d.RetryAfter != BaseLockout {
+			t.Fatalf("ロック中の Allow = %+v", d)
+		}
+	}
+	if d := g.Allow(ctx, "U2"); !d.Allowed {
+		t.Error("別のユーザーまでロックしました")
+	}
+
+	advance(BaseLockout + time.Second)
+	if d := g.Allow(ctx, "U1"); !d.Allowed {
+		t.Fatalf("ロックが解けません: %+v", d)
+	}
+	if lock := g.Failure(ctx, "U1"); lock != 2*BaseLockout {
+		t.Fatalf("2 回目のロック = %v, want %v", lock, 2*BaseLockout)
+	}
+
+	// ロック中の試行はロックごとに 1 回だけ記録する
+	want := []string{"lockout", "locked_attempt", "lockout"}
+	if got := events(); !reflect.DeepEqual(got, want) {
+		t.Errorf("記録 = %v, want %v", got, want)
+	}
+
+	// 成功したら数え直す
+	advance(2*BaseLockout + time.Second)
+	g.Success("U1")
+	for i := 0; i < FreeAttempts; i++ {
+		if lock := g.Failure(ctx, "U1"); lock != 0 {
+			t.Fatalf("成功後 %d 回目の失敗でロックしました: %v", i+1, lock)
+		}
+	}
+}
+
+// 最後の失敗から FailureWindow が過ぎたら数え直す
+func TestFailureWindow(t *testing.T) {
+	advance, _ := setup(t)
+	ctx := context.Background()
+	g := New(testEvents, 100, 100, "")
+
+	for i := 0; i < FreeAttempts; i++ {
+		g.Failure(ctx, "U1")
+	}
+	advance(FailureWindow)
+	if lock := g.Failure(ctx, "U1"); lock != BaseLockout {
+		t.Fatalf("FailureWindow ちょうどで数え直しました: %v", lock)
+	}
+
+	advance(BaseLockout + FailureWindow + time.Second)
+	if lock := g.Failure(ctx, "U1"); lock != 0 {
+		t.Fatalf("FailureWindow を過ぎても数え直しません: %v", lock)
+	}
+}
+
+func TestGlobalBucket(t *testing.T) {
+	advance, events := setup(t)
+	ctx := context.Background()
+	g := New(testEvents, 2, 3, "ip") // 2 回/秒、最大 3 回
+
+	keys := []string{"a", "b", "c", "d", "e"}
+	for _, k := range keys[:3] {
+		if d := g.Allow(ctx, k); !d.Allowed {
+			t.Fatalf("%s: バーストの範囲で拒否しました", k)
+		}
+	}
+	if d := g.Allow(ctx, "d"); d.Allowed || d.RetryAfter != 500*time.Millisecond {
+		t.Fatalf("使い切った後の Allow = %+v, want 500ms 後", d)
+	}
+
+	advance(250 * time.Millisecond)
+	if d := g.Allow(ctx, "d"); d.Allowed || d.RetryAfter != 250*time.Millisecond {
+		t.Fatalf("回復途中の Allow = %+v, want 250ms 後", d)
+	}
+	advance(250 * time.Millisecond)
+	if d := g.Allow(ctx, "d"); !d.Allowed {
+		t.Fatalf("回復後に拒否しました: %+v", d)
+	}
+
+	// 長く空いてもバーストより多くは貯まらない
+	advance(time.Hour)
+	for _, k := range keys[:3] {
+		if d := g.Allow(ctx, k); !d.Allowed {
+			t.Fatalf("%s: 回復後のバーストで拒否しました", k)
+		}
+	}
+	if d := g.Allow(ctx, "e"); d.Allowed {
+		t.Fatal("バーストを超えて許可しました")
+	}
+
+	// 全体制限の記録は 1 分に 1 回まで
+	want := []string{"global_limited", "global_limited"}
+	if got := events(); !reflect.DeepEqual(got, want) {
+		t.Errorf("記録 = %v, want %v", got, want)
+	}
+}
@@ -2,33 +2,59 @@ package azure
 
 import (
 	"context"
-	"fmt"
+	"log/slog"
 	"os"
 	"time"
 
+	"go_project/config"
+	"go_project/logging"
+	"go_project/metrics"
+	"go_project/tracing"
+
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-var(
-	AZURE_STORAGE_ACCOUNT=os.Getenv("AZURE_STORAGE_ACCOUNT")
-	AZURE_STORAGE_KEY=os.Getenv("AZURE_STORAGE_KEY")
-)
+var cfg config.Azure
 
+// 設定を差し替える（作成済みのクライアント・委任キーは破棄する）
+func Configure(c config.Azure) {
+	mu.Lock()
+	defer mu.Unlock()
+	cfg = c
+	blobClient, sharedCred = nil, nil
+	delegationCred, delegationExpiry = nil, time.Time{}
+}
 
-func UploadDocx(container, blobName, localPath string) error {
+// 生成文書の保存先コンテナ
+func Container() string {
+	return cfg.Container
+}
 
 
-	cred,err:=azblob.NewSharedKeyCredential(AZURE_STORAGE_ACCOUNT,AZURE_STORAGE_KEY)
-	if err!=nil {
+// 保存先コンテナにアクセスできるか（認証・権限・コンテナの有無）を確かめる
+func Ping(ctx context.Context) error {
+	client, err := getClient()
+	if err != nil {
 		return err
 	}
+	_, err = client.ServiceClient().NewContainerClient(cfg.Container).GetProperties(ctx, nil)
+	if err != nil && fallbackOnTokenError(ctx, err) {
+		return Ping(ctx)
+	}
+	return err
+}
 
-	serviceURL:=fmt.Sprintf("https://%s.blob.core.windows.net/",AZURE_STORAGE_ACCOUNT)
+// meta はBLOBメタデータとインデックスタグの両方に付与する
+func UploadDocx(ctx context.Context, container, blobName, localPath string, meta DocMeta) (err error) {
+	ctx, span := tracing.Start(ctx, "azure.upload",
+		attribute.String("azure.container", container), attribute.String("azure.blob", blobName))
+	defer func() { tracing.End(span, err) }()
 
-	client,err:=azblob.NewClientWithSharedKeyCredential(serviceURL,cred,nil)
+	client,err:=getClient()
 	if err != nil {
     	return err
 	}
@@ -39,8 +65,15 @@ func UploadDocx(container, blobName, localPath string) error {
     }
     defer f.Close()
 
+    var size int64
+    if fi, err := f.Stat(); err == nil {
+        size = fi.Size()
+    }
+    span.SetAttributes(attribute.Int64("azure.upload.bytes", size))
+
+    start := time.Now()
     _, err = client.UploadFile(
-        context.Background(),
+        ctx,
         container,    // コンテナ名
         blobName,     // BLOB名
         f,            // *os.File
@@ -48,14 +81,30 @@ func UploadDocx(container, blobName, localPath string) error {
             HTTPHeaders: &blob.HTTPHeaders{ // ← azblob.HTTPHeaders ではなく blob.HTTPHeaders
                 BlobContentType: to.Ptr("application/vnd.openxmlformats-officedocument.wordprocessingml.document"),
             },
+            Metadata: meta.metadata(),
+            Tags:     meta.values(),
         },
     )
-  
-	return err
+	metrics.AzureUpload(size, start, err)
+	if err != nil && fallbackOnTokenError(ctx, err) {
+		return UploadDocx(ctx, container, blobName, localPath, meta)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "BLOB アップロード失敗", "container", container, "blob", blobName, logging.Err(err))
+		return err
+	}
+	slog.InfoContext(ctx, "BLOB アップロード", "container", container, "blob", blobName,
+		"duration_ms", time.Since(start).Milliseconds())
+	return nil
 }
 
-func GenerateBlobSASURL(containerName,blobName string,expireMinutes int)(string,error){
-	cred,err:=azblob.NewSharedKeyCredential(AZURE_STORAGE_ACCOUNT,AZURE_STORAGE_KEY)
+// 共有キー方式ならアカウントキー、Azure AD 方式ならユーザー委任キーで署名する
+func GenerateBlobSASURL(ctx context.Context,containerName,blobName string,expireMinutes int)(_ string,err error){
+	ctx, span := tracing.Start(ctx, "azure.sas",
+		attribute.String("azure.container", containerName), attribute.String("azure.blob", blobName))
+	defer func() { tracing.End(span, err) }()
+
+	client,err:=getClient()
 	if err!=nil {
 		return "",err
 	}
@@ -68,25 +117,23 @@ func GenerateBlobSASURL(containerName,blobName string,expireMinutes int)(string,
 	expireTime:=time.Now().Add(time.Duration(expireMinutes)*time.Minute)
 
 
-	sasQueryParams,err:=sas.BlobSignatureValues{
-		Protocol: sas.ProtocolHTTPS,
+	sasQueryParams,err:=signBlobSAS(ctx,client,sas.BlobSignatureValues{
+		Protocol: sasProtocol(),
 		StartTime: startTime,
 		ExpiryTime: expireTime,
 		Permissions: permissions.String(),
 		ContainerName: containerName,
 		BlobName: blobName,
-	}.SignWithSharedKey(cred)
+	})
+	if err!=nil && fallbackOnTokenError(ctx, err) {
+		return GenerateBlobSASURL(ctx, containerName, blobName, expireMinutes)
+	}
 	if err!=nil {
+		slog.ErrorContext(ctx, "SAS 署名失敗", "container", containerName, "blob", blobName, logging.Err(err))
 		return "",err
 	}
 
-	sasURL:=fmt.Sprintf(
-		"https://%s.blob.core.windows.net/%s/%s?%s",
-		AZURE_STORAGE_ACCOUNT,
-		containerName,
-		blobName,
-		sasQueryParams.Encode(),
-	)
+	blobURL:=client.ServiceClient().NewContainerClient(containerName).NewBlobClient(blobName).URL()
 
-	return sasURL,nil
-}
\ No newline at end of file
+	return blobURL+"?"+sasQueryParams.Encode(),nil
+}
@@ -0,0 +1,255 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go_project/config"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+/* =======================
+   Azurite を使った結合テスト
+======================= */
+
+// AZURITE_BLOB_ENDPOINT（例 http://127.0.0.1:10000/devstoreaccount1）がなければスキップする。
+// 起動例: docker run -p 10000:10000 mcr.microsoft.com/azure-storage/azurite azurite-blob --blobHost 0.0.0.0
+
+// Azurite の既定アカウント（公開されている開発用の値）
+const (
+	azuriteAccount = "devstoreaccount1"
+	azuriteKey     = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+)
+
+// c の認証方式で Configure し、テスト用のコンテナを作る（終了時に削除）。
+// クライアントは初回の呼び出しで作るので、資格情報の差し替えはこの後でよい。
+func setupAzurite(t *testing.T, c config.Azure) config.Azure {
+	t.Helper()
+	endpoint := os.Getenv("AZURITE_BLOB_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("AZURITE_BLOB_ENDPOINT が未設定のためスキップします")
+	}
+
+	c.Account = azuriteAccount
+	c.Endpoint = endpoint
+	c.Container = fmt.Sprintf("test-%d", time.Now().UnixNano())
+
+	// コンテナは認証方式によらず共有キーで作る
+	cred, err := azblob.NewSharedKeyCredential(azuriteAccount, azuriteKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := azblob.NewClientWithSharedKeyCredential(strings.TrimRight(endpoint, "/")+"/", cred, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := admin.CreateContainer(ctx, c.Container, nil); err != nil {
+		t.Fatalf("コンテナ作成失敗: %v", err)
+	}
+
+	t.Cleanup(func() {
+		newTokenCredential = tokenCredential
+		Configure(config.Azure{})
+		admin.DeleteContainer(context.Background(), c.Container, nil)
+	})
+	Configure(c)
+	return c
+}
+
+func writeDocx(t *testing.T, body []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "output.docx")
+	if err := os.WriteFile(path, body, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// アップロードしてタグを確かめ、SAS URL でダウンロードできることを確かめる
+func uploadAndDownload(t *testing.T, c config.Azure) {
+	t.Helper()
+	ctx := context.Background()
+	body := []byte("docx body " + c.Container)
+	meta := DocMeta{LineUserID: "U0123", JobID: "job-1", TemplateHash: "tmpl", Model: "gemini-test", Version: 1}
+
+	if err := UploadDocx(ctx, c.Container, "job-1.docx", writeDocx(t, body), meta); err != nil {
+		t.Fatalf("UploadDocx: %v", err)
+	}
+
+	doc, err := getUserDocument(ctx, c.Container, "job-1.docx")
+	if err != nil {
+		t.Fatalf("getUserDocument: %v", err)
+	}
+	if doc.JobID != "job-1" || doc.Model != "gemini-test" || doc.Version != 1 {
+		t.Errorf("タグ = %+v", doc)
+	}
+
+	u, err := GenerateBlobSASURL(ctx, c.Container, "job-1.docx", 5)
+	if err != nil {
+		t.Fatalf("GenerateBlobSASURL: %v", err)
+	}
+	if !strings.Contains(u, "sig=") || !strings.Contains(u, "sp=r") {
+		t.Errorf("SAS URL = %s", u)
+	}
+	res, err := http.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	got, _ := io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK || !bytes.Equal(got, body) {
+		t.Fatalf("SAS URL でのダウンロード = %d %q", res.StatusCode, got)
+	}
+
+	// 署名を外すと読めない（コンテナは非公開）
+	res, err = http.Get(u[:strings.Index(u, "?")])
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		t.Error("SAS なしでダウンロードできてしまいました")
+	}
+}
+
+func TestAzuriteSharedKey(t *testing.T) {
+	c := setupAzurite(t, config.Azure{AuthMode: AuthSharedKey, Key: azuriteKey})
+	if err := Ping(context.Background()); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	uploadAndDownload(t, c)
+}
+
+// Azure AD の資格情報を作れなければ、最初から共有キーを使う
+func TestAzuriteFallbackWhenADInitFails(t *testing.T) {
+	c := setupAzurite(t, config.Azure{AuthMode: AuthClientSecret, Key: azuriteKey}) // テナントIDなどがない
+	uploadAndDownload(t, c)
+	if sharedCred == nil {
+		t.Error("共有キーに切り替わっていません")
+	}
+}
+
+type failingCredential struct{ calls *int }
+
+func (c failingCredential) GetToken(context.Context, policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	*c.calls++
+	return azcore.AccessToken{}, errors.New("token endpoint unavailable")
+}
+
+// 資格情報は作れたが実行時にトークンを取れないときも、共有キーに切り替えてやり直す
+func TestAzuriteFallbackWhenTokenFails(t *testing.T) {
+	c := setupAzurite(t, config.Azure{AuthMode: AuthManagedIdentity, Key: azuriteKey})
+	calls := 0
+	newTokenCredential = func(string) (azcore.TokenCredential, error) {
+		return failingCredential{&calls}, nil
+	}
+	uploadAndDownload(t, c)
+	if calls == 0 {
+		t.Error("Azure AD のトークン取得を試していません")
+	}
+	if sharedCred == nil {
+		t.Error("共有キーに切り替わっていません")
+	}
+}
+
+// アカウントキーがなければ切り替えずにエラーを返す
+func TestAzuriteTokenFailsWithoutKey(t *testing.T) {
+	c := setupAzurite(t, config.Azure{AuthMode: AuthManagedIdentity})
+	newTokenCredential = func(string) (azcore.TokenCredential, error) {
+		return failingCredential{new(int)}, nil
+	}
+	err := UploadDocx(context.Background(), c.Container, "job-1.docx", writeDocx(t, []byte("x")), DocMeta{JobID: "job-1"})
+	if err == nil {
+		t.Fatal("トークンを取れないのにアップロードできました")
+	}
+}
+
+type staticCredential struct{}
+
+func (staticCredential) GetToken(context.Context, policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// ユーザー委任キーの取得中も mu を持たない（他の呼び出しを待たせない）。同時の署名は 1 回の取得を共有する
+func TestDelegationFetchDoesNotHoldLock(t *testing.T) {
+	started, release := make(chan struct{}, 2), make(chan struct{})
+	var fetches int
+	var fetchMu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("comp") != "userdelegationkey" {
+			http.NotFound(w, r)
+			return
+		}
+		fetchMu.Lock()
+		fetches++
+		fetchMu.Unlock()
+		started <- struct{}{}
+		<-release
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><UserDelegationKey>`+
+			`<SignedOid>oid</SignedOid><SignedTid>tid</SignedTid>`+
+			`<SignedStart>2026-10-01T00:00:00Z</SignedStart><SignedExpiry>2026-10-02T00:00:00Z</SignedExpiry>`+
+			`<SignedService>b</SignedService><SignedVersion>2023-11-03</SignedVersion>`+
+			`<Value>%s</Value></UserDelegationKey>`, base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	}))
+	defer srv.Close()
+
+	Configure(config.Azure{AuthMode: AuthManagedIdentity, Account: azuriteAccount, Endpoint: srv.URL + "/" + azuriteAccount, Container: "docs"})
+	newTokenCredential = func(string) (azcore.TokenCredential, error) { return staticCredential{}, nil }
+	t.Cleanup(func() {
+		newTokenCredential = tokenCredential
+		Configure(config.Azure{})
+	})
+
+	ctx := context.Background()
+	urls := make(chan string, 2)
+	sign := func() {
+		u, err := GenerateBlobSASURL(ctx, "docs", "job-1.docx", 5)
+		if err != nil {
+			t.Error(err)
+		}
+		urls <- u
+	}
+	go sign()
+	<-started
+	go sign() // 先の取得を待つ
+
+	done := make(chan struct{})
+	go func() {
+		getClient()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		close(release) // srv.Close が止まった取得を待たないように
+		t.Fatal("ユーザー委任キーの取得中に getClient が待たされました")
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if u := <-urls; !strings.Contains(u, "sig=") || !strings.Contains(u, "skoid=oid") {
+			t.Errorf("SAS URL = %s", u)
+		}
+	}
+	fetchMu.Lock()
+	defer fetchMu.Unlock()
+	if fetches != 1 {
+		t.Errorf("ユーザー委任キーの取得 %d 回, want 1", fetches)
+	}
+}
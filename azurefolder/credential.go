@@ -0,0 +1,279 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"go_project/logging"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+)
+
+/* =======================
+   認証方式
+======================= */
+
+// AZURE_AUTH_MODE（config.Azure.AuthMode）に指定できる値
+const (
+	AuthSharedKey        = "sharedkey"    // アカウントキー（従来方式）
+	AuthDefault          = "default"      // DefaultAzureCredential（環境に応じて自動選択）
+	AuthManagedIdentity  = "managed"      // マネージドID
+	AuthWorkloadIdentity = "workload"     // ワークロードID（AKS等）
+	AuthClientSecret     = "clientsecret" // サービスプリンシパル＋シークレット
+)
+
+// ユーザー委任キーの有効期間（最大7日）
+const delegationKeyLifetime = 6 * time.Hour
+
+var (
+	mu         sync.Mutex
+	blobClient *azblob.Client
+	sharedCred *azblob.SharedKeyCredential // 共有キー方式のときのみ非nil
+
+	delegationCred   *service.UserDelegationCredential
+	delegationExpiry time.Time
+
+	// ユーザー委任キーの取得を 1 件ずつにする（取得中も mu は持たないので共有キーの署名等は待たない）
+	delegationMu sync.Mutex
+)
+
+// 実際に使う認証方式（未指定ならキーの有無で決める）
+func authMode() string {
+	mode := cfg.AuthMode
+	if mode != "" {
+		return mode
+	}
+	if cfg.Key != "" {
+		return AuthSharedKey
+	}
+	return AuthDefault
+}
+
+func serviceURL() string {
+	if cfg.Endpoint != "" {
+		return strings.TrimRight(cfg.Endpoint, "/") + "/"
+	}
+	return fmt.Sprintf("https://%s.blob.core.windows.net/", cfg.Account)
+}
+
+// テストで差し替えられるよう変数にしておく
+var newTokenCredential = tokenCredential
+
+func tokenCredential(mode string) (azcore.TokenCredential, error) {
+	switch mode {
+	case AuthDefault:
+		return azidentity.NewDefaultAzureCredential(nil)
+	case AuthManagedIdentity:
+		var opts azidentity.ManagedIdentityCredentialOptions
+		if cfg.ClientID != "" {
+			// ユーザー割り当てマネージドID
+			opts.ID = azidentity.ClientID(cfg.ClientID)
+		}
+		return azidentity.NewManagedIdentityCredential(&opts)
+	case AuthWorkloadIdentity:
+		return azidentity.NewWorkloadIdentityCredential(nil)
+	case AuthClientSecret:
+		if cfg.TenantID == "" || cfg.ClientID == "" || cfg.ClientSecret == "" {
+			return nil, errors.New("AZURE_TENANT_ID,AZURE_CLIENT_ID,AZURE_CLIENT_SECRETが見つかりません")
+		}
+		return azidentity.NewClientSecretCredential(cfg.TenantID, cfg.ClientID, cfg.ClientSecret.Value(), nil)
+	}
+	return nil, fmt.Errorf("不明なAZURE_AUTH_MODEです: %s", mode)
+}
+
+func newSharedKeyClient() (*azblob.Client, *azblob.SharedKeyCredential, error) {
+	if cfg.Account == "" || cfg.Key == "" {
+		return nil, nil, errors.New("AZURE_STORAGE_ACCOUNT,AZURE_STORAGE_KEYが見つかりません")
+	}
+	cred, err := azblob.NewSharedKeyCredential(cfg.Account, cfg.Key.Value())
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := azblob.NewClientWithSharedKeyCredential(serviceURL(), cred, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, cred, nil
+}
+
+// 設定に応じたクライアントを返す（初回のみ生成）
+func getClient() (*azblob.Client, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if blobClient != nil {
+		return blobClient, nil
+	}
+
+	mode := authMode()
+	if mode == AuthSharedKey {
+		client, cred, err := newSharedKeyClient()
+		if err != nil {
+			return nil, err
+		}
+		blobClient, sharedCred = client, cred
+		return blobClient, nil
+	}
+
+	if cfg.Account == "" && cfg.Endpoint == "" {
+		return nil, errors.New("AZURE_STORAGE_ACCOUNTが見つかりません")
+	}
+
+	tokenCred, err := newTokenCredential(mode)
+	if err == nil {
+		var client *azblob.Client
+		opts := &azblob.ClientOptions{}
+		// エミュレータ（http）でも AD 方式を試せるようにする（本番のエンドポイントは https のみ）
+		opts.InsecureAllowCredentialWithHTTP = sasProtocol() == sas.ProtocolHTTPSandHTTP
+		client, err = azblob.NewClient(serviceURL(), watchedCredential{tokenCred}, opts)
+		if err == nil {
+			blobClient = client
+			return blobClient, nil
+		}
+	}
+
+	// Azure AD が使えずアカウントキーがある場合は従来方式にフォールバック
+	// （実行時のトークン取得の失敗は fallbackOnTokenError で切り替える）
+	if cfg.Key == "" {
+		return nil, fmt.Errorf("Azure AD 認証の初期化失敗(%s): %w", mode, err)
+	}
+	slog.Warn("Azure AD 認証に失敗したため共有キーを使用します", "mode", mode, logging.Err(err))
+	client, cred, err := newSharedKeyClient()
+	if err != nil {
+		return nil, err
+	}
+	blobClient, sharedCred = client, cred
+	return blobClient, nil
+}
+
+// トークンの取得に失敗したことを呼び出し側で見分けられるようにする TokenCredential。
+// 資格情報の生成に成功しても、実行時（期限切れ・権限の剥奪・IMDS の障害など）に失敗することがある。
+type watchedCredential struct {
+	azcore.TokenCredential
+}
+
+func (c watchedCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	tk, err := c.TokenCredential.GetToken(ctx, opts)
+	if err != nil {
+		return tk, &tokenError{err}
+	}
+	return tk, nil
+}
+
+type tokenError struct{ err error }
+
+func (e *tokenError) Error() string { return e.err.Error() }
+func (e *tokenError) Unwrap() error { return e.err }
+
+// azcore に再試行させない（資格情報の問題は再試行しても直らない）
+func (*tokenError) NonRetriable() {}
+
+// Azure AD のトークン取得に失敗し、アカウントキーがあれば共有キーのクライアントに切り替える。
+// 切り替えた（または切り替え済みの）ときは true を返すので、呼び出し側でやり直す。
+func fallbackOnTokenError(ctx context.Context, err error) bool {
+	var te *tokenError
+	if cfg.Key == "" || !errors.As(err, &te) {
+		return false
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if sharedCred != nil {
+		return true
+	}
+	client, cred, kerr := newSharedKeyClient()
+	if kerr != nil {
+		slog.ErrorContext(ctx, "共有キーへの切り替え失敗", logging.Err(kerr))
+		return false
+	}
+	slog.WarnContext(ctx, "Azure AD のトークン取得に失敗したため共有キーに切り替えます", "mode", authMode(), logging.Err(te.err))
+	blobClient, sharedCred = client, cred
+	delegationCred, delegationExpiry = nil, time.Time{}
+	return true
+}
+
+// 有効期限内のユーザー委任キー（なければ nil）
+func cachedDelegationCredential(until time.Time) *service.UserDelegationCredential {
+	mu.Lock()
+	defer mu.Unlock()
+	if delegationCred != nil && delegationExpiry.After(until) {
+		return delegationCred
+	}
+	return nil
+}
+
+// ユーザー委任キーを取得（有効期限内はキャッシュを使う）
+func userDelegationCredential(ctx context.Context, client *azblob.Client, until time.Time) (*service.UserDelegationCredential, error) {
+	if udc := cachedDelegationCredential(until); udc != nil {
+		return udc, nil
+	}
+
+	// 同時に来た署名は先の取得の結果を使う
+	delegationMu.Lock()
+	defer delegationMu.Unlock()
+	if udc := cachedDelegationCredential(until); udc != nil {
+		return udc, nil
+	}
+
+	now := time.Now().UTC()
+	expiry := now.Add(delegationKeyLifetime)
+	if expiry.Before(until) {
+		expiry = until
+	}
+
+	info := service.KeyInfo{
+		Start:  toISO(now.Add(-5 * time.Minute)),
+		Expiry: toISO(expiry),
+	}
+	udc, err := client.ServiceClient().GetUserDelegationCredential(ctx, info, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ユーザー委任キー取得失敗: %w", err)
+	}
+
+	mu.Lock()
+	// 取得中に共有キーへ切り替わっていれば、もう使わないので残さない
+	if sharedCred == nil {
+		delegationCred, delegationExpiry = udc, expiry
+	}
+	mu.Unlock()
+	return udc, nil
+}
+
+// 共有キーまたはユーザー委任キーで署名する
+func signBlobSAS(ctx context.Context, client *azblob.Client, values sas.BlobSignatureValues) (sas.QueryParameters, error) {
+	mu.Lock()
+	cred := sharedCred
+	mu.Unlock()
+
+	if cred != nil {
+		return values.SignWithSharedKey(cred)
+	}
+
+	udc, err := userDelegationCredential(ctx, client, values.ExpiryTime)
+	if err != nil {
+		return sas.QueryParameters{}, err
+	}
+	return values.SignWithUserDelegation(udc)
+}
+
+// エミュレータ（http）ではHTTPS限定のSASが使えない
+func sasProtocol() sas.Protocol {
+	if strings.HasPrefix(strings.ToLower(serviceURL()), "http://") {
+		return sas.ProtocolHTTPSandHTTP
+	}
+	return sas.ProtocolHTTPS
+}
+
+func toISO(t time.Time) *string {
+	s := t.UTC().Format(sas.TimeFormat)
+	return &s
+}
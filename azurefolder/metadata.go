@@ -0,0 +1,185 @@
+package azure
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"go_project/tracing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+/* =======================
+   生成文書のメタデータ／インデックスタグ
+======================= */
+
+// タグ・メタデータのキー（メタデータはC#識別子の制約があるため同じ名前を使う）
+const (
+	TagLineUserHash = "line_user_hash"
+	TagJobID        = "job_id"
+	TagTemplateHash = "template_hash"
+	TagModel        = "model"
+	TagCreatedAt    = "created_at"
+	TagParentJobID  = "parent_job_id"
+	TagVersion      = "version"
+)
+
+// 生成文書と利用者・ジョブの対応情報
+type DocMeta struct {
+	LineUserID   string // 保存時はハッシュ化する
+	JobID        string
+	TemplateHash string
+	Model        string
+	CreatedAt    time.Time
+	ParentJobID  string // 修正元（#修正 で作った版のみ）
+	Version      int    // 1 が最初の生成
+}
+
+// 検索結果の1件
+type UserDocument struct {
+	Container    string
+	BlobName     string
+	JobID        string
+	TemplateHash string
+	Model        string
+	CreatedAt    time.Time
+	ParentJobID  string
+	Version      int
+}
+
+// LINEユーザーIDはそのまま保存せずSHA-256で照合する
+func HashUserID(lineUserID string) string {
+	sum := sha256.Sum256([]byte(lineUserID))
+	return hex.EncodeToString(sum[:])
+}
+
+// テンプレートJSONのハッシュ（どのテンプレートから生成したかの識別用）
+func HashTemplate(templateJSON string) string {
+	sum := sha256.Sum256([]byte(templateJSON))
+	return hex.EncodeToString(sum[:])
+}
+
+func (m DocMeta) values() map[string]string {
+	created := m.CreatedAt
+	if created.IsZero() {
+		created = time.Now()
+	}
+	v := map[string]string{
+		TagCreatedAt: created.UTC().Format(time.RFC3339),
+	}
+	if m.LineUserID != "" {
+		v[TagLineUserHash] = HashUserID(m.LineUserID)
+	}
+	if m.JobID != "" {
+		v[TagJobID] = m.JobID
+	}
+	if m.TemplateHash != "" {
+		v[TagTemplateHash] = m.TemplateHash
+	}
+	if m.Model != "" {
+		v[TagModel] = m.Model
+	}
+	if m.ParentJobID != "" {
+		v[TagParentJobID] = m.ParentJobID
+	}
+	if m.Version > 0 {
+		v[TagVersion] = strconv.Itoa(m.Version)
+	}
+	return v
+}
+
+func (m DocMeta) metadata() map[string]*string {
+	md := map[string]*string{}
+	for k, v := range m.values() {
+		md[k] = to.Ptr(v)
+	}
+	return md
+}
+
+// 指定ユーザーが生成した文書をインデックスタグから検索する（新しい順）
+func FindUserDocuments(ctx context.Context, containerName, lineUserID string) (_ []UserDocument, err error) {
+	ctx, span := tracing.Start(ctx, "azure.find_documents", attribute.String("azure.container", containerName))
+	defer func() { tracing.End(span, err) }()
+	client, err := getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	where := fmt.Sprintf(`@container='%s' AND "%s"='%s'`, containerName, TagLineUserHash, HashUserID(lineUserID))
+
+	var docs []UserDocument
+	var marker *string
+	for {
+		resp, err := client.ServiceClient().FilterBlobs(ctx, where, &service.FilterBlobsOptions{Marker: marker})
+		if err != nil && marker == nil && fallbackOnTokenError(ctx, err) {
+			return FindUserDocuments(ctx, containerName, lineUserID)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("タグ検索失敗: %w", err)
+		}
+
+		for _, item := range resp.Blobs {
+			if item == nil || item.Name == nil {
+				continue
+			}
+			doc, err := getUserDocument(ctx, containerName, *item.Name)
+			if err != nil {
+				return nil, err
+			}
+			docs = append(docs, doc)
+		}
+
+		if resp.NextMarker == nil || *resp.NextMarker == "" {
+			break
+		}
+		marker = resp.NextMarker
+	}
+
+	sort.Slice(docs, func(i, j int) bool {
+		return docs[i].CreatedAt.After(docs[j].CreatedAt)
+	})
+	return docs, nil
+}
+
+// FilterBlobs は条件に使ったタグしか返さないので全タグを取り直す
+func getUserDocument(ctx context.Context, containerName, blobName string) (UserDocument, error) {
+	client, err := getClient()
+	if err != nil {
+		return UserDocument{}, err
+	}
+
+	blobClient := client.ServiceClient().NewContainerClient(containerName).NewBlobClient(blobName)
+	resp, err := blobClient.GetTags(ctx, nil)
+	if err != nil {
+		return UserDocument{}, fmt.Errorf("タグ取得失敗(%s): %w", blobName, err)
+	}
+
+	doc := UserDocument{Container: containerName, BlobName: blobName}
+	for _, t := range resp.BlobTagSet {
+		if t == nil || t.Key == nil || t.Value == nil {
+			continue
+		}
+		switch *t.Key {
+		case TagJobID:
+			doc.JobID = *t.Value
+		case TagTemplateHash:
+			doc.TemplateHash = *t.Value
+		case TagModel:
+			doc.Model = *t.Value
+		case TagCreatedAt:
+			doc.CreatedAt, _ = time.Parse(time.RFC3339, *t.Value)
+		case TagParentJobID:
+			doc.ParentJobID = *t.Value
+		case TagVersion:
+			doc.Version, _ = strconv.Atoi(*t.Value)
+		}
+	}
+	return doc, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"go_project/extraction"
+	"go_project/logging"
+	"go_project/router"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+/* =======================
+   生成結果のカード
+======================= */
+
+// ダウンロードリンクの有効時間（分）
+const linkMinutes = 5
+
+type result struct {
+	JobID     string
+	Version   int // 1 が最初の生成、#修正 のたびに増える
+	URL       string
+	Expires   time.Time // リンクの有効期限
+	CreatedAt time.Time
+	Duration  time.Duration // 生成にかかった時間
+	Summary   extraction.Summary
+	Revised   []string // 修正したセクションの見出し
+}
+
+func (r result) title() string {
+	if r.Summary.Title == "" {
+		return "生成した文書"
+	}
+	return r.Summary.Title
+}
+
+func (r result) stats() string {
+	return fmt.Sprintf("約%dページ・%d文字", r.Summary.Pages, r.Summary.Characters)
+}
+
+func (r result) created() string {
+	return fmt.Sprintf("%s（%d秒）", r.CreatedAt.In(jst).Format("2006/01/02 15:04"), int(r.Duration.Round(time.Second).Seconds()))
+}
+
+func (r result) heading() string {
+	if r.Version > 1 {
+		return fmt.Sprintf("第%d版ができました", r.Version)
+	}
+	return "文書が完成しました"
+}
+
+func (r result) revised() string {
+	return strings.Join(r.Revised, "、")
+}
+
+func (r result) expires() string {
+	return r.Expires.In(jst).Format("15:04") + "まで"
+}
+
+// 結果を Flex Message で送る。送れなければテキストで送り直す。
+func sendResult(ctx context.Context, req *router.Request, r result) {
+	if err := req.Replier.Reply(ctx, resultCard(r)); err != nil {
+		slog.WarnContext(ctx, "結果カードの送信に失敗したためテキストで送ります", "job_id", r.JobID, logging.Err(err))
+		req.ReplyText(ctx, resultText(r))
+	}
+}
+
+// Flex Message に対応しない環境（通知・PC版の一部）向け
+func resultText(r result) string {
+	text := "✅ " + r.heading() + "\n" +
+		r.title() + "\n" +
+		r.stats() + "\n"
+	if len(r.Revised) > 0 {
+		text += "修正箇所 " + r.revised() + "\n"
+	}
+	return text +
+		"生成日時 " + r.created() + "\n" +
+		"ダウンロード（" + r.expires() + "）\n" + r.URL
+}
+
+func resultCard(r result) linebot.SendingMessage {
+	row := func(label, value string) linebot.FlexComponent {
+		return &linebot.BoxComponent{
+			Type:    linebot.FlexComponentTypeBox,
+			Layout:  linebot.FlexBoxLayoutTypeBaseline,
+			Spacing: linebot.FlexComponentSpacingTypeSm,
+			Contents: []linebot.FlexComponent{
+				&linebot.TextComponent{Type: linebot.FlexComponentTypeText, Text: label, Size: linebot.FlexTextSizeTypeSm, Color: "#8c8c8c", Flex: linebot.IntPtr(2)},
+				&linebot.TextComponent{Type: linebot.FlexComponentTypeText, Text: value, Size: linebot.FlexTextSizeTypeSm, Wrap: true, Flex: linebot.IntPtr(5)},
+			},
+		}
+	}
+
+	rows := []linebot.FlexComponent{
+		row("分量", r.stats()),
+		row("生成日時", r.created()),
+		row("リンク", r.expires()),
+	}
+	if len(r.Revised) > 0 {
+		rows = append(rows, row("修正箇所", r.revised()))
+	}
+
+	bubble := &linebot.BubbleContainer{
+		Type: linebot.FlexContainerTypeBubble,
+		Body: &linebot.BoxComponent{
+			Type:    linebot.FlexComponentTypeBox,
+			Layout:  linebot.FlexBoxLayoutTypeVertical,
+			Spacing: linebot.FlexComponentSpacingTypeMd,
+			Contents: []linebot.FlexComponent{
+				&linebot.TextComponent{Type: linebot.FlexComponentTypeText, Text: r.heading(), Size: linebot.FlexTextSizeTypeSm, Color: "#06c755", Weight: linebot.FlexTextWeightTypeBold},
+				&linebot.TextComponent{Type: linebot.FlexComponentTypeText, Text: r.title(), Size: linebot.FlexTextSizeTypeLg, Weight: linebot.FlexTextWeightTypeBold, Wrap: true, MaxLines: linebot.IntPtr(3)},
+				&linebot.SeparatorComponent{Type: linebot.FlexComponentTypeSeparator},
+				&linebot.BoxComponent{
+					Type:     linebot.FlexComponentTypeBox,
+					Layout:   linebot.FlexBoxLayoutTypeVertical,
+					Spacing:  linebot.FlexComponentSpacingTypeSm,
+					Contents: rows,
+				},
+			},
+		},
+		Footer: &linebot.BoxComponent{
+			Type:    linebot.FlexComponentTypeBox,
+			Layout:  linebot.FlexBoxLayoutTypeVertical,
+			Spacing: linebot.FlexComponentSpacingTypeSm,
+			Contents: []linebot.FlexComponent{
+				&linebot.ButtonComponent{
+					Type:   linebot.FlexComponentTypeButton,
+					Style:  linebot.FlexButtonStyleTypePrimary,
+					Action: linebot.NewURIAction("ダウンロード", r.URL),
+				},
+				&linebot.BoxComponent{
+					Type:    linebot.FlexComponentTypeBox,
+					Layout:  linebot.FlexBoxLayoutTypeHorizontal,
+					Spacing: linebot.FlexComponentSpacingTypeSm,
+					Contents: []linebot.FlexComponent{
+						&linebot.ButtonComponent{
+							Type:   linebot.FlexComponentTypeButton,
+							Style:  linebot.FlexButtonStyleTypeSecondary,
+							Action: linebot.NewPostbackAction("修正", router.PostbackData("#修正", ""), "", "#修正", "", ""),
+						},
+						&linebot.ButtonComponent{
+							Type:   linebot.FlexComponentTypeButton,
+							Style:  linebot.FlexButtonStyleTypeSecondary,
+							Action: linebot.NewPostbackAction("再生成", router.PostbackData("#再生成", r.JobID), "", "#再生成", "", ""),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	// 通知・トーク一覧に出る文言（URL は出さない）
+	alt := r.heading() + "：" + r.title()
+	if n := []rune(alt); len(n) > 400 {
+		alt = string(n[:400])
+	}
+	return linebot.NewFlexMessage(alt, bubble)
+}
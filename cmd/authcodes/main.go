@@ -0,0 +1,237 @@
+// 認証コードの発行・一覧・失効を行う管理用コマンド
+//
+//	go run ./cmd/authcodes generate -n 50 -batch 2026-spring -plan standard -expires 2026-12-31 -csv codes.csv
+//	go run ./cmd/authcodes list -batch 2026-spring -status unused
+//	go run ./cmd/authcodes revoke -batch 2026-spring
+//	go run ./cmd/authcodes revoke ABCD2345EF GHJK6789MN
+//
+// SUPABASE_URL と SUPABASE_SERVICE_ROLE_KEY（環境変数または CONFIG_FILE / .env）が必要です。
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"go_project/config"
+	"go_project/supabase"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `使い方: authcodes <command> [options]
+
+commands:
+  generate  認証コードを発行する
+  list      認証コードを一覧表示する
+  revoke    未使用の認証コードを失効させる
+
+各コマンドのオプションは authcodes <command> -h で確認できます。`)
+}
+
+// Supabase の設定だけあればよい
+func configure() error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	if err := cfg.Supabase.Validate(); err != nil {
+		return err
+	}
+	supabase.Configure(cfg.Supabase)
+	return nil
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	if err := configure(); err != nil {
+		fmt.Fprintln(os.Stderr, "❌", err)
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	var err error
+	switch os.Args[1] {
+	case "generate":
+		err = runGenerate(ctx, os.Args[2:])
+	case "list":
+		err = runList(ctx, os.Args[2:])
+	case "revoke":
+		err = runRevoke(ctx, os.Args[2:])
+	case "-h", "--help", "help":
+		usage()
+		return
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "❌", err)
+		os.Exit(1)
+	}
+}
+
+func runGenerate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	n := fs.Int("n", 50, "発行数")
+	length := fs.Int("len", supabase.DefaultCodeLength, "コードの文字数")
+	batch := fs.String("batch", "", "バッチ名（配布単位の識別用）")
+	plan := fs.String("plan", supabase.PlanStandard, "プラン（free / standard / pro）")
+	expires := fs.String("expires", "", "有効期限（YYYY-MM-DD、その日の終わりまで有効）")
+	csvPath := fs.String("csv", "", "CSVの出力先（- で標準出力）")
+	fs.Parse(args)
+
+	opts := supabase.IssueOptions{
+		Count:  *n,
+		Length: *length,
+		Batch:  *batch,
+		Plan:   *plan,
+	}
+	if *expires != "" {
+		t, err := parseExpiry(*expires)
+		if err != nil {
+			return err
+		}
+		opts.ExpiresAt = &t
+	}
+
+	codes, err := supabase.IssueAuthCodes(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	if *csvPath != "" {
+		if err := exportCSV(*csvPath, codes); err != nil {
+			return err
+		}
+	} else {
+		for _, c := range codes {
+			fmt.Println(c.Code)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "✅ 認証コード%d件を登録しました\n", len(codes))
+	return nil
+}
+
+func runList(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	batch := fs.String("batch", "", "バッチ名で絞り込む")
+	status := fs.String("status", "", "状態で絞り込む（unused / used / revoked）")
+	limit := fs.Int("limit", 0, "最大件数（0で無制限）")
+	csvPath := fs.String("csv", "", "CSVの出力先（- で標準出力）")
+	fs.Parse(args)
+
+	codes, err := supabase.ListAuthCodes(ctx, supabase.AuthCodeFilter{
+		Batch:  *batch,
+		Status: *status,
+		Limit:  *limit,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *csvPath != "" {
+		return exportCSV(*csvPath, codes)
+	}
+
+	for _, c := range codes {
+		fmt.Printf("%s\t%s\t%s\t%s\t%s\n", c.Code, c.Batch, c.Plan, codeStatus(c), formatTime(c.ExpiresAt))
+	}
+	fmt.Fprintf(os.Stderr, "%d件\n", len(codes))
+	return nil
+}
+
+func runRevoke(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("revoke", flag.ExitOnError)
+	batch := fs.String("batch", "", "バッチ内の未使用コードをすべて失効させる")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "使い方: authcodes revoke [-batch NAME] [CODE...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	revoked, err := supabase.RevokeAuthCodes(ctx, *batch, fs.Args())
+	if err != nil {
+		return err
+	}
+	for _, c := range revoked {
+		fmt.Println(c.Code)
+	}
+	fmt.Fprintf(os.Stderr, "✅ %d件を失効させました\n", len(revoked))
+	return nil
+}
+
+// 配布用CSV
+func exportCSV(path string, codes []supabase.AuthCode) error {
+	var w io.Writer = os.Stdout
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"code", "batch", "plan", "status", "expires_at", "used_by", "used_at", "created_at"})
+	for _, c := range codes {
+		usedBy := ""
+		if c.UsedBy != nil {
+			usedBy = *c.UsedBy
+		}
+		cw.Write([]string{
+			c.Code,
+			c.Batch,
+			c.Plan,
+			codeStatus(c),
+			formatTime(c.ExpiresAt),
+			usedBy,
+			formatTime(c.UsedAt),
+			c.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func codeStatus(c supabase.AuthCode) string {
+	switch {
+	case c.RevokedAt != nil:
+		return supabase.CodeStatusRevoked
+	case c.Used:
+		return supabase.CodeStatusUsed
+	case c.ExpiresAt != nil && c.ExpiresAt.Before(time.Now()):
+		return "expired"
+	}
+	return supabase.CodeStatusUnused
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// 日付のみ指定された場合は日本時間のその日の終わりまで有効
+func parseExpiry(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	jst := time.FixedZone("JST", 9*60*60)
+	d, err := time.ParseInLocation("2006-01-02", s, jst)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("有効期限の形式が不正です（YYYY-MM-DD）: %s", strconv.Quote(s))
+	}
+	return d.AddDate(0, 0, 1).Add(-time.Second), nil
+}
@@ -0,0 +1,127 @@
+// LINEユーザーの利用状況確認と利用停止を行う管理用コマンド
+//
+//	go run ./cmd/users show Uxxxxxxxx
+//	go run ./cmd/users revoke -reason "返金対応" Uxxxxxxxx
+//
+// SUPABASE_URL と SUPABASE_SERVICE_ROLE_KEY（環境変数または CONFIG_FILE / .env）が必要です。
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"go_project/config"
+	"go_project/supabase"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `使い方: users <command> [options] LINE_USER_ID
+
+commands:
+  show    ユーザーのプラン・期限・状態を表示する
+  revoke  ユーザーを利用停止する（生成・会話は即時、その他の操作は最大5分で反映）`)
+}
+
+// Supabase の設定だけあればよい
+func configure() error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	if err := cfg.Supabase.Validate(); err != nil {
+		return err
+	}
+	supabase.Configure(cfg.Supabase)
+	return nil
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	if err := configure(); err != nil {
+		fmt.Fprintln(os.Stderr, "❌", err)
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	var err error
+	switch os.Args[1] {
+	case "show":
+		err = runShow(ctx, os.Args[2:])
+	case "revoke":
+		err = runRevoke(ctx, os.Args[2:])
+	case "-h", "--help", "help":
+		usage()
+		return
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "❌", err)
+		os.Exit(1)
+	}
+}
+
+func runShow(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("show", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("LINE_USER_ID を1つ指定してください")
+	}
+
+	user, err := supabase.GetUserByLineID(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return errors.New("ユーザーが見つかりません")
+	}
+	printUser(user)
+	return nil
+}
+
+func runRevoke(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("revoke", flag.ExitOnError)
+	reason := fs.String("reason", "", "停止理由（管理用メモ）")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("LINE_USER_ID を1つ指定してください")
+	}
+
+	user, err := supabase.RevokeUser(ctx, fs.Arg(0), *reason)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return errors.New("ユーザーが見つかりません")
+	}
+	printUser(user)
+	fmt.Fprintln(os.Stderr, "✅ 利用停止しました")
+	return nil
+}
+
+func printUser(u *supabase.User) {
+	fmt.Printf("line_user_id: %s\n", u.LineUserID)
+	fmt.Printf("plan:         %s\n", u.Plan)
+	fmt.Printf("status:       %s\n", u.Status(time.Now()))
+	if u.ExpiresAt != nil {
+		fmt.Printf("expires_at:   %s\n", u.ExpiresAt.Format(time.RFC3339))
+	} else {
+		fmt.Println("expires_at:   (無期限)")
+	}
+	if u.RevokedAt != nil {
+		fmt.Printf("revoked_at:   %s\n", u.RevokedAt.Format(time.RFC3339))
+	}
+	if u.RevokedReason != nil && *u.RevokedReason != "" {
+		fmt.Printf("reason:       %s\n", *u.RevokedReason)
+	}
+}
@@ -0,0 +1,501 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* =======================
+   設定
+======================= */
+
+// Secret はログや fmt に出しても値が漏れない文字列
+type Secret string
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return "[REDACTED]"
+}
+
+func (s Secret) GoString() string { return s.String() }
+
+func (s Secret) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+// 実際の値
+func (s Secret) Value() string { return string(s) }
+
+type Config struct {
+	Port string
+	HTTP HTTP
+
+	Features Features
+
+	LINE      LINE
+	Supabase  Supabase
+	Azure     Azure
+	Gemini    Gemini
+	Unioffice Unioffice
+	Quota     Quota
+	Admin     Admin
+	Log       Log
+	Metrics   Metrics
+	Tracing   Tracing
+	RichMenu  RichMenu
+}
+
+// HTTP サーバーのタイムアウト（生成は Webhook 内で同期処理するので書き込みは長め）
+type HTTP struct {
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration // SIGTERM 後、処理中の Webhook・生成を待つ上限
+}
+
+// 有効にする機能（無効な機能の設定は検証しない）
+type Features struct {
+	Chat     bool // 会話モード
+	Generate bool // 文書生成モード
+	Admin    bool // 管理API・管理画面（トークンかパスワードがあれば有効）
+	Metrics  bool // /metrics（Prometheus。Webhook と同じホストで公開されるので既定は無効）
+	RichMenu bool // 起動時にリッチメニューを作成・更新する
+}
+
+type LINE struct {
+	ChannelSecret      Secret
+	ChannelAccessToken Secret
+}
+
+type Supabase struct {
+	URL            string
+	ServiceRoleKey Secret
+}
+
+type Azure struct {
+	AuthMode     string // sharedkey / default / managed / workload / clientsecret（空ならキーの有無で決める）
+	Account      string
+	Key          Secret
+	Endpoint     string // Azurite 等
+	TenantID     string
+	ClientID     string
+	ClientSecret Secret
+	Container    string
+}
+
+type Gemini struct {
+	APIKey     Secret
+	PromptPath string
+}
+
+type Tracing struct {
+	Endpoint    string // OTLP/HTTP のベースURL（例 http://localhost:4318）。空なら無効
+	ServiceName string
+	SampleRatio float64 // 0〜1
+}
+
+type Metrics struct {
+	Token Secret // Bearer トークン（/metrics を有効にするなら必須）
+}
+
+// リッチメニューの画像（guest.png・member.png、2500x843）。空なら単色の区画を描く
+type RichMenu struct {
+	ImageDir string
+}
+
+type Log struct {
+	Level  string // debug / info / warn / error
+	Format string // json / text
+	Redact bool   // ユーザーIDとメッセージ本文を伏せる（既定 true）
+}
+
+type Unioffice struct {
+	APIKey  Secret
+	Backend string // auto / unioffice / ooxml（auto はライセンスが使えれば unioffice）
+}
+
+type Quota struct {
+	ResetDay int // 1〜28
+}
+
+type Admin struct {
+	APIToken      Secret
+	Password      Secret
+	SessionSecret Secret
+	TrustProxy    bool // X-Forwarded-For の最後のアドレスを接続元とみなす（リバースプロキシの後ろで動かすとき）
+}
+
+// Load は設定ファイル（CONFIG_FILE、既定は .env があれば）と環境変数から設定を読む。
+// 同じキーは環境変数が優先される。検証は Validate で行う。
+func Load() (*Config, error) {
+	file := map[string]string{}
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		if _, err := os.Stat(".env"); err == nil {
+			path = ".env"
+		}
+	}
+	if path != "" {
+		var err error
+		if file, err = readFile(path); err != nil {
+			return nil, fmt.Errorf("設定ファイル読み込み失敗(%s): %w", path, err)
+		}
+	}
+
+	get := func(key string) string {
+		if v, ok := os.LookupEnv(key); ok {
+			return v
+		}
+		return file[key]
+	}
+
+	var errs []error
+	getBool := func(key string, def bool) bool {
+		v := get(key)
+		if v == "" {
+			return def
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: 真偽値ではありません: %q", key, v))
+			return def
+		}
+		return b
+	}
+	getDuration := func(key string, def time.Duration) time.Duration {
+		v := get(key)
+		if v == "" {
+			return def
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: 時間の形式ではありません（例 30s）: %q", key, v))
+			return def
+		}
+		return d
+	}
+	getInt := func(key string, def int) int {
+		v := get(key)
+		if v == "" {
+			return def
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: 整数ではありません: %q", key, v))
+			return def
+		}
+		return n
+	}
+
+	c := &Config{
+		Port: get("PORT"),
+		HTTP: HTTP{
+			ReadTimeout:     getDuration("HTTP_READ_TIMEOUT", 15*time.Second),
+			WriteTimeout:    getDuration("HTTP_WRITE_TIMEOUT", 3*time.Minute),
+			IdleTimeout:     getDuration("HTTP_IDLE_TIMEOUT", 2*time.Minute),
+			ShutdownTimeout: getDuration("SHUTDOWN_TIMEOUT", 3*time.Minute),
+		},
+		LINE: LINE{
+			ChannelSecret:      Secret(get("LINE_CHANNEL_SECRET")),
+			ChannelAccessToken: Secret(get("LINE_CHANNEL_ACCESS_TOKEN")),
+		},
+		Supabase: Supabase{
+			URL:            strings.TrimRight(get("SUPABASE_URL"), "/"),
+			ServiceRoleKey: Secret(get("SUPABASE_SERVICE_ROLE_KEY")),
+		},
+		Azure: Azure{
+			AuthMode:     strings.ToLower(strings.TrimSpace(get("AZURE_AUTH_MODE"))),
+			Account:      get("AZURE_STORAGE_ACCOUNT"),
+			Key:          Secret(get("AZURE_STORAGE_KEY")),
+			Endpoint:     get("AZURE_STORAGE_ENDPOINT"),
+			TenantID:     get("AZURE_TENANT_ID"),
+			ClientID:     get("AZURE_CLIENT_ID"),
+			ClientSecret: Secret(get("AZURE_CLIENT_SECRET")),
+			Container:    get("AZURE_STORAGE_CONTAINER"),
+		},
+		Gemini: Gemini{
+			APIKey:     Secret(get("GEMINI_API_KEY")),
+			PromptPath: get("GEMINI_PROMPT_PATH"),
+		},
+		Unioffice: Unioffice{
+			APIKey:  Secret(get("UNICLOUD_API_KEY")),
+			Backend: strings.ToLower(strings.TrimSpace(get("DOCX_BACKEND"))),
+		},
+		Quota: Quota{
+			ResetDay: getInt("QUOTA_RESET_DAY", 1),
+		},
+		Admin: Admin{
+			APIToken:      Secret(get("ADMIN_API_TOKEN")),
+			Password:      Secret(get("ADMIN_PASSWORD")),
+			SessionSecret: Secret(get("ADMIN_SESSION_SECRET")),
+			TrustProxy:    getBool("ADMIN_TRUST_PROXY", false),
+		},
+	}
+
+	c.Log = Log{
+		Level:  strings.ToLower(strings.TrimSpace(get("LOG_LEVEL"))),
+		Format: strings.ToLower(strings.TrimSpace(get("LOG_FORMAT"))),
+		Redact: getBool("LOG_REDACT", true),
+	}
+
+	c.Features = Features{
+		Chat:     getBool("ENABLE_CHAT", true),
+		Generate: getBool("ENABLE_GENERATE", true),
+		Admin:    c.Admin.APIToken != "" || c.Admin.Password != "",
+		Metrics:  getBool("ENABLE_METRICS", false),
+		RichMenu: getBool("ENABLE_RICH_MENU", false),
+	}
+	c.RichMenu = RichMenu{
+		ImageDir: get("RICH_MENU_IMAGE_DIR"),
+	}
+	c.Metrics = Metrics{
+		Token: Secret(get("METRICS_TOKEN")),
+	}
+	c.Tracing = Tracing{
+		Endpoint:    strings.TrimSpace(get("OTEL_EXPORTER_OTLP_ENDPOINT")),
+		ServiceName: get("OTEL_SERVICE_NAME"),
+		SampleRatio: 1,
+	}
+	if v := get("OTEL_SAMPLE_RATIO"); v != "" {
+		r, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("OTEL_SAMPLE_RATIO: 数値ではありません: %q", v))
+		} else {
+			c.Tracing.SampleRatio = r
+		}
+	}
+
+	if c.Port == "" {
+		c.Port = "10000"
+	}
+	if c.Azure.Container == "" {
+		c.Azure.Container = "documents"
+	}
+	if c.Unioffice.Backend == "" {
+		c.Unioffice.Backend = "auto"
+	}
+	if c.Tracing.ServiceName == "" {
+		c.Tracing.ServiceName = "line-ai-bot"
+	}
+	if c.Log.Level == "" {
+		c.Log.Level = "info"
+	}
+	if c.Log.Format == "" {
+		c.Log.Format = "json"
+	}
+	if c.Gemini.PromptPath == "" {
+		c.Gemini.PromptPath = "prompt.txt"
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return c, nil
+}
+
+// KEY=VALUE 形式（# 始まりはコメント、値の前後の引用符は外す）
+func readFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	out := map[string]string{}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%d行目: KEY=VALUE の形式ではありません", n)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		out[strings.TrimSpace(key)] = value
+	}
+	return out, sc.Err()
+}
+
+/* =======================
+   検証
+======================= */
+
+// 有効な機能に必要な設定がそろっているか
+func (c *Config) Validate() error {
+	var errs []error
+	add := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	add(c.HTTP.Validate())
+	add(c.LINE.Validate())
+	add(c.Supabase.Validate())
+	if c.Features.Chat || c.Features.Generate {
+		add(c.Gemini.Validate())
+	}
+	if c.Features.Generate {
+		add(c.Azure.Validate())
+		add(c.Unioffice.Validate())
+	}
+	if c.Features.Admin {
+		add(c.Admin.Validate())
+	}
+	if c.Features.Metrics {
+		add(c.Metrics.Validate())
+	}
+	add(c.Quota.Validate())
+	add(c.Log.Validate())
+	add(c.Tracing.Validate())
+
+	return errors.Join(errs...)
+}
+
+func missing(keys ...string) error {
+	return fmt.Errorf("%s が設定されていません", strings.Join(keys, ","))
+}
+
+func (c HTTP) Validate() error {
+	if c.ReadTimeout <= 0 || c.WriteTimeout <= 0 || c.IdleTimeout <= 0 || c.ShutdownTimeout <= 0 {
+		return fmt.Errorf("HTTP_*_TIMEOUT・SHUTDOWN_TIMEOUT は正の値で指定してください")
+	}
+	return nil
+}
+
+func (c LINE) Validate() error {
+	if c.ChannelSecret == "" || c.ChannelAccessToken == "" {
+		return missing("LINE_CHANNEL_SECRET", "LINE_CHANNEL_ACCESS_TOKEN")
+	}
+	return nil
+}
+
+func (c Supabase) Validate() error {
+	if c.URL == "" || c.ServiceRoleKey == "" {
+		return missing("SUPABASE_URL", "SUPABASE_SERVICE_ROLE_KEY")
+	}
+	if !strings.HasPrefix(c.URL, "https://") && !strings.HasPrefix(c.URL, "http://") {
+		return fmt.Errorf("SUPABASE_URL は http(s):// で始めてください")
+	}
+	return nil
+}
+
+func (c Azure) Validate() error {
+	switch c.AuthMode {
+	case "", "sharedkey":
+		if c.AuthMode == "sharedkey" || c.Key != "" {
+			if c.Account == "" || c.Key == "" {
+				return missing("AZURE_STORAGE_ACCOUNT", "AZURE_STORAGE_KEY")
+			}
+			return nil
+		}
+	case "default", "managed", "workload":
+	case "clientsecret":
+		if c.TenantID == "" || c.ClientID == "" || c.ClientSecret == "" {
+			return missing("AZURE_TENANT_ID", "AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET")
+		}
+	default:
+		return fmt.Errorf("AZURE_AUTH_MODE が不正です: %q", c.AuthMode)
+	}
+
+	if c.Account == "" && c.Endpoint == "" {
+		return missing("AZURE_STORAGE_ACCOUNT")
+	}
+	return nil
+}
+
+func (c Gemini) Validate() error {
+	if c.APIKey == "" {
+		return missing("GEMINI_API_KEY")
+	}
+	return nil
+}
+
+// キーがなくても純Go実装で動くので、unioffice を明示したときだけ必須
+func (c Unioffice) Validate() error {
+	switch c.Backend {
+	case "", "auto", "ooxml":
+	case "unioffice":
+		if c.APIKey == "" {
+			return missing("UNICLOUD_API_KEY")
+		}
+	default:
+		return fmt.Errorf("DOCX_BACKEND が不正です: %q", c.Backend)
+	}
+	return nil
+}
+
+func (c Quota) Validate() error {
+	if c.ResetDay < 1 || c.ResetDay > 28 {
+		return fmt.Errorf("QUOTA_RESET_DAY は1〜28で指定してください: %d", c.ResetDay)
+	}
+	return nil
+}
+
+func (c Tracing) Validate() error {
+	if c.Endpoint != "" && !strings.HasPrefix(c.Endpoint, "https://") && !strings.HasPrefix(c.Endpoint, "http://") {
+		return fmt.Errorf("OTEL_EXPORTER_OTLP_ENDPOINT は http(s):// で始めてください")
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("OTEL_SAMPLE_RATIO は0〜1で指定してください: %g", c.SampleRatio)
+	}
+	return nil
+}
+
+func (c Log) Validate() error {
+	switch c.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("LOG_LEVEL が不正です: %q", c.Level)
+	}
+	switch c.Format {
+	case "json", "text":
+	default:
+		return fmt.Errorf("LOG_FORMAT が不正です: %q", c.Format)
+	}
+	return nil
+}
+
+func (c Metrics) Validate() error {
+	if c.Token == "" {
+		return fmt.Errorf("ENABLE_METRICS には METRICS_TOKEN が必要です")
+	}
+	return nil
+}
+
+func (c Admin) Validate() error {
+	if c.Password != "" && len(c.Password) < 12 {
+		return fmt.Errorf("ADMIN_PASSWORD は12文字以上にしてください")
+	}
+	if c.APIToken != "" && len(c.APIToken) < 32 {
+		return fmt.Errorf("ADMIN_API_TOKEN は32文字以上にしてください")
+	}
+	return nil
+}
+
+// 起動ログ用（秘密情報は伏せる）
+func (c *Config) Summary() string {
+	return fmt.Sprintf(
+		"port=%s http(read=%s write=%s idle=%s shutdown=%s) chat=%t generate=%t admin=%t(trust_proxy=%t) metrics=%t(token=%s) supabase=%s azure(mode=%s account=%s endpoint=%s container=%s key=%s) gemini(key=%s prompt=%s) docx(backend=%s unioffice_key=%s) quota(reset_day=%d) log(level=%s format=%s redact=%t) tracing(endpoint=%s service=%s ratio=%g) richmenu=%t(images=%s)",
+		c.Port, c.HTTP.ReadTimeout, c.HTTP.WriteTimeout, c.HTTP.IdleTimeout, c.HTTP.ShutdownTimeout, c.Features.Chat, c.Features.Generate, c.Features.Admin, c.Admin.TrustProxy, c.Features.Metrics, c.Metrics.Token,
+		c.Supabase.URL,
+		c.Azure.AuthMode, c.Azure.Account, c.Azure.Endpoint, c.Azure.Container, c.Azure.Key,
+		c.Gemini.APIKey, c.Gemini.PromptPath,
+		c.Unioffice.Backend, c.Unioffice.APIKey,
+		c.Quota.ResetDay,
+		c.Log.Level, c.Log.Format, c.Log.Redact,
+		c.Tracing.Endpoint, c.Tracing.ServiceName, c.Tracing.SampleRatio,
+		c.Features.RichMenu, c.RichMenu.ImageDir,
+	)
+}
@@ -0,0 +1,113 @@
+package extraction
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"go_project/config"
+	"go_project/logging"
+	"go_project/metrics"
+	"go_project/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+/* =======================
+   DOCX 読み書きの実装切り替え
+======================= */
+
+const (
+	BackendUnioffice = "unioffice" // unioffice（メータードライセンスが必要）
+	BackendOOXML     = "ooxml"     // archive/zip + encoding/xml による純Go実装
+)
+
+// Backend は DocTemplate と .docx ファイルの相互変換
+type Backend interface {
+	Name() string
+	Read(path string) (*DocTemplate, error)
+	Write(template *DocTemplate, outputPath string) error
+}
+
+// ライセンス未設定でも動くよう既定は純Go実装
+var backend Backend = ooxmlBackend{}
+
+// Configure は c.Backend に応じて実装を選ぶ。
+// auto ではライセンス設定に失敗しても起動を止めず純Go実装に切り替える。
+func Configure(c config.Unioffice) error {
+	switch c.Backend {
+	case BackendOOXML:
+		backend = ooxmlBackend{}
+	case BackendUnioffice:
+		if err := setUniofficeLicense(c.APIKey.Value()); err != nil {
+			return err
+		}
+		backend = uniofficeBackend{}
+	case "", "auto":
+		if err := setUniofficeLicense(c.APIKey.Value()); err != nil {
+			slog.Warn("unioffice を使わず純Go実装で DOCX を扱います", logging.Err(err))
+			backend = ooxmlBackend{}
+		} else {
+			backend = uniofficeBackend{}
+		}
+	default:
+		return fmt.Errorf("不明な DOCX_BACKEND です: %s", c.Backend)
+	}
+	slog.Info("DOCX backend", "backend", backend.Name())
+	return nil
+}
+
+// 使用中の実装名
+func BackendName() string {
+	return backend.Name()
+}
+
+// Word → JSON 抽出
+func ExtractWordStructure(ctx context.Context, path string) (*DocTemplate, error) {
+	ctx, span := tracing.Start(ctx, "extraction.read", attribute.String("docx.backend", backend.Name()))
+	start := time.Now()
+	err := checkPackageSize(path)
+	var t *DocTemplate
+	if err == nil {
+		t, err = backend.Read(path)
+	}
+	size := fileSize(path)
+	metrics.Extraction(backend.Name(), "read", size, start, err)
+	span.SetAttributes(attribute.Int64("docx.bytes", size))
+	tracing.End(span, err)
+	if err != nil {
+		slog.ErrorContext(ctx, "Word 構造抽出失敗", "backend", backend.Name(), logging.Err(err))
+		return nil, err
+	}
+	slog.DebugContext(ctx, "Word 構造抽出", "backend", backend.Name(),
+		"sections", len(t.Sections), "duration_ms", time.Since(start).Milliseconds())
+	return t, nil
+}
+
+// JSON → Word 再構築
+func ApplyJSONToWordStruct(ctx context.Context, template *DocTemplate, outputPath string) error {
+	ctx, span := tracing.Start(ctx, "extraction.write", attribute.String("docx.backend", backend.Name()))
+	start := time.Now()
+	err := backend.Write(template, outputPath)
+	size := fileSize(outputPath)
+	metrics.Extraction(backend.Name(), "write", size, start, err)
+	span.SetAttributes(attribute.Int64("docx.bytes", size))
+	tracing.End(span, err)
+	if err != nil {
+		slog.ErrorContext(ctx, "Word 書き出し失敗", "backend", backend.Name(), logging.Err(err))
+		return err
+	}
+	slog.DebugContext(ctx, "Word 書き出し", "backend", backend.Name(),
+		"sections", len(template.Sections), "duration_ms", time.Since(start).Milliseconds())
+	return nil
+}
+
+func fileSize(path string) int64 {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
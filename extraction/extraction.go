@@ -4,9 +4,8 @@ import (
 	"archive/zip"
 	"encoding/xml"
 	"fmt"
-	"io"
-	"log"
-	"os"
+	"go_project/logging"
+	"log/slog"
 	"strings"
 
 	"github.com/unidoc/unioffice/common"
@@ -24,6 +23,7 @@ type DocTemplate struct {
     Type         string       `json:"type"`
     Sections     []Section    `json:"sections"`
     PageSettings *wml.CT_SectPr  `json:"-"` // 用紙サイズ・余白情報を保持
+    PageSettingsXML []byte       `json:"-"` // 同上（純Go実装用の w:sectPr そのもの）
 }
 type Section struct {
     Title *Block  `json:"title,omitempty"`
@@ -52,17 +52,24 @@ type ImageBlock struct {
 }
 
 /* =======================
-   ライセンス
+   unioffice 実装
 ======================= */
 
-func init() {
-    key := os.Getenv("UNICLOUD_API_KEY")
+type uniofficeBackend struct{}
+
+func (uniofficeBackend) Name() string { return BackendUnioffice }
+
+// メータードライセンスを設定する（オフライン等で失敗したら使えない）
+func setUniofficeLicense(key string) error {
+    if key == "" {
+        return fmt.Errorf("UNICLOUD_API_KEY が設定されていません")
+    }
     if err := license.SetMeteredKey(key); err != nil {
-        log.Fatalf("UniOffice ライセンス設定失敗: %v", err)
+        return fmt.Errorf("UniOffice ライセンス設定失敗: %w", err)
     }
+    return nil
 }
 
-
 /* =======================
    ページ設定抽出
 ======================= */
@@ -76,11 +83,26 @@ func extractPageSettings(doc *document.Document) *wml.CT_SectPr {
     return &copy
 }
 
+// 用紙サイズ・余白などの設定。Word構造JSON には含まれないので、
+// テンプレートから生成した文書へはこれで引き継ぐ。
+type PageSettings struct {
+    SectPr *wml.CT_SectPr // unioffice で読んだとき
+    XML    []byte         // 純Go実装で読んだとき
+}
+
+func (t *DocTemplate) Page() PageSettings {
+    return PageSettings{SectPr: t.PageSettings, XML: t.PageSettingsXML}
+}
+
+func (t *DocTemplate) SetPage(p PageSettings) {
+    t.PageSettings, t.PageSettingsXML = p.SectPr, p.XML
+}
+
 /* =======================
    Word → JSON 抽出
 ======================= */
 
-func ExtractWordStructure(path string) (*DocTemplate, error) {
+func (uniofficeBackend) Read(path string) (*DocTemplate, error) {
     doc, err := document.Open(path)
     if err != nil {
         return nil, err
@@ -97,7 +119,7 @@ func ExtractWordStructure(path string) (*DocTemplate, error) {
 
     linkMap, err := buildHyperlinkMapFromXML(path)
     if err != nil {
-        log.Printf("警告: ハイパーリンクURL抽出に失敗: %v", err)
+        slog.Warn("ハイパーリンクURL抽出に失敗", logging.Err(err))
     }
 
     for pi, p := range doc.Paragraphs() {
@@ -306,9 +328,9 @@ func buildHyperlinkMapFromXML(docxPath string) (map[int][]xmlHyperlink, error) {
     for _, f := range rc.File {
         switch f.Name {
         case "word/document.xml":
-            r, _ := f.Open(); docXML, _ = io.ReadAll(r); r.Close()
+            docXML, _ = readZipFile(f)
         case "word/_rels/document.xml.rels":
-            r, _ := f.Open(); relsXML, _ = io.ReadAll(r); r.Close()
+            relsXML, _ = readZipFile(f)
         }
     }
     if len(docXML) == 0 || len(relsXML) == 0 {
@@ -355,7 +377,7 @@ func buildHyperlinkMapFromXML(docxPath string) (map[int][]xmlHyperlink, error) {
    JSON → Word 再構築
 ======================= */
 
-func ApplyJSONToWordStruct(template *DocTemplate, outputPath string) error {
+func (uniofficeBackend) Write(template *DocTemplate, outputPath string) error {
     doc := document.New()
 
     // ページ設定をコピー
@@ -410,10 +432,12 @@ func ApplyJSONToWordStruct(template *DocTemplate, outputPath string) error {
                     }
                 }
             case "image":
-                if b.Image != nil {
+                // データのない画像（差し込まれなかった図の位置）や読めない画像は飛ばす
+                if b.Image != nil && len(b.Image.Data) > 0 {
                     img, err := common.ImageFromBytes(b.Image.Data)
                     if err != nil {
-                        return err
+                        slog.Warn("画像を読み込めないため省きます", "name", b.Image.Name, logging.Err(err))
+                        continue
                     }
                     imgRef, err := doc.AddImage(img)
                     if err != nil {
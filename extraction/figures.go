@@ -0,0 +1,126 @@
+package extraction
+
+import (
+	"fmt"
+	"strings"
+)
+
+/* =======================
+   ユーザーの図（写真）の差し込み
+======================= */
+
+// ユーザーが送った図
+type Figure struct {
+	Name    string // 文書内で一意（figure1.jpeg 等）
+	Data    []byte
+	Caption string // 任意
+}
+
+// 図番号付きの見出し（「図1 説明」）
+func (f Figure) Label(n int) string {
+	label := fmt.Sprintf("図%d", n)
+	if f.Caption != "" {
+		label += " " + f.Caption
+	}
+	return label
+}
+
+// PlaceFigures は文書の画像ブロックを figs で置き換える。
+// 差し込む先はデータのない画像ブロック（Gemini が図の位置に置いたもの）を文書の順に先に使い、
+// 余った図だけテンプレートにある画像を先頭から置き換える（ロゴ等より図の位置を優先する）。
+// 置き換えた画像の直後が「図」で始まる段落なら見出しを付け替え、なければ見出しを足す。
+// 画像ブロックより図が多ければ、最後のセクションの末尾に見出し付きで足す。
+// 表のセルはどちらの実装でもテキストしか書き出さないので、セル内の画像ブロックには差し込まない。
+func PlaceFigures(t *DocTemplate, figs []Figure) {
+	if len(figs) == 0 {
+		return
+	}
+
+	// 画像ブロックの位置 → 差し込む図の番号
+	type pos struct{ sec, block int }
+	var empty, filled []pos
+	for si, sec := range t.Sections {
+		for bi, b := range sec.Body {
+			switch {
+			case b.Kind != "image":
+			case b.Image == nil || len(b.Image.Data) == 0:
+				empty = append(empty, pos{si, bi})
+			default:
+				filled = append(filled, pos{si, bi})
+			}
+		}
+	}
+	assign := map[pos]int{}
+	for n, p := range append(empty, filled...) {
+		if n >= len(figs) {
+			break
+		}
+		assign[p] = n
+	}
+
+	fill := func(si int, blocks []Block) []Block {
+		out := make([]Block, 0, len(blocks))
+		for i := 0; i < len(blocks); i++ {
+			b := blocks[i]
+			n, ok := assign[pos{si, i}]
+			if !ok {
+				out = append(out, b)
+				continue
+			}
+
+			f := figs[n]
+			b.Image = &ImageBlock{Name: f.Name, Data: f.Data}
+			out = append(out, b)
+
+			caption := captionBlock(f.Label(n + 1))
+			if i+1 < len(blocks) && isCaption(blocks[i+1]) {
+				if f.Caption == "" {
+					continue // テンプレート側の見出しをそのまま使う
+				}
+				// 書式は元の見出しに合わせる
+				caption.Style = blocks[i+1].Style
+				if len(blocks[i+1].Runs) > 0 {
+					run := blocks[i+1].Runs[0]
+					run.Text = caption.Runs[0].Text
+					caption.Runs = []Run{run}
+				}
+				i++
+			}
+			out = append(out, caption)
+		}
+		return out
+	}
+
+	for i := range t.Sections {
+		t.Sections[i].Body = fill(i, t.Sections[i].Body)
+	}
+
+	next := len(assign)
+	if next >= len(figs) {
+		return
+	}
+	if len(t.Sections) == 0 {
+		t.Sections = append(t.Sections, Section{})
+	}
+	last := &t.Sections[len(t.Sections)-1]
+	for ; next < len(figs); next++ {
+		f := figs[next]
+		last.Body = append(last.Body,
+			Block{Kind: "image", Image: &ImageBlock{Name: f.Name, Data: f.Data}},
+			captionBlock(f.Label(next+1)),
+		)
+	}
+}
+
+func captionBlock(text string) Block {
+	return Block{Kind: "paragraph", Runs: []Run{{Text: text}}}
+}
+
+// 「図1 …」「図 2」のような段落
+func isCaption(b Block) bool {
+	if b.Kind != "paragraph" {
+		return false
+	}
+	text := runsText(b.Runs)
+	return strings.HasPrefix(text, "図") || strings.HasPrefix(strings.ToLower(text), "fig")
+}
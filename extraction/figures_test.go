@@ -0,0 +1,139 @@
+package extraction
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"path/filepath"
+	"testing"
+)
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 3))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// 書き出して読み直した本文（表のセルを除く）の画像と、その直後の段落
+func roundTripFigures(t *testing.T, tmpl *DocTemplate) (images [][]byte, captions []string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "out.docx")
+	if err := (ooxmlBackend{}).Write(tmpl, path); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	got, err := (ooxmlBackend{}).Read(path)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	for _, sec := range got.Sections {
+		for i, b := range sec.Body {
+			if b.Kind != "image" || b.Image == nil {
+				continue
+			}
+			images = append(images, b.Image.Data)
+			if i+1 < len(sec.Body) {
+				captions = append(captions, runsText(sec.Body[i+1].Runs))
+			}
+		}
+	}
+	return images, captions
+}
+
+func TestPlaceFiguresSkipsTableCells(t *testing.T) {
+	data := testPNG(t)
+	tests := []struct {
+		name string
+		body []Block
+	}{
+		{
+			name: "表の後ろの画像ブロックに入る",
+			body: []Block{
+				{Kind: "table", Rows: [][]Block{{{Kind: "image"}, {Kind: "paragraph", Runs: []Run{{Text: "説明"}}}}}},
+				{Kind: "image"},
+				{Kind: "paragraph", Runs: []Run{{Text: "図1 元の図"}}},
+			},
+		},
+		{
+			name: "本文に画像ブロックがなければ末尾に足す",
+			body: []Block{
+				{Kind: "paragraph", Runs: []Run{{Text: "本文"}}},
+				{Kind: "table", Rows: [][]Block{{{Kind: "image"}}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl := &DocTemplate{Sections: []Section{{Body: tt.body}}}
+			PlaceFigures(tmpl, []Figure{{Name: "figure1.png", Data: data, Caption: "実験装置"}})
+
+			for _, b := range tmpl.Sections[0].Body {
+				for _, row := range b.Rows {
+					for _, cell := range row {
+						if cell.Image != nil {
+							t.Fatalf("表のセルに図が差し込まれました: %+v", cell.Image)
+						}
+					}
+				}
+			}
+
+			images, captions := roundTripFigures(t, tmpl)
+			if len(images) != 1 || !bytes.Equal(images[0], data) {
+				t.Fatalf("書き出した文書に図がありません（画像 %d 個）", len(images))
+			}
+			if len(captions) != 1 || captions[0] != "図1 実験装置" {
+				t.Errorf("見出し = %q, want %q", captions, "図1 実験装置")
+			}
+		})
+	}
+}
+
+// Gemini が置いたデータのない画像ブロックを、テンプレートの画像（ロゴ等）より先に使う
+func TestPlaceFiguresPrefersPlaceholders(t *testing.T) {
+	logo, fig1, fig2 := testPNG(t), []byte("figure1"), []byte("figure2")
+	body := func() []Block {
+		return []Block{
+			{Kind: "image", Image: &ImageBlock{Name: "logo.png", Data: logo}},
+			{Kind: "paragraph", Runs: []Run{{Text: "本文"}}},
+			{Kind: "image"},
+			{Kind: "paragraph", Runs: []Run{{Text: "図1 装置"}}},
+		}
+	}
+	figs := []Figure{{Name: "figure1.png", Data: fig1, Caption: "実験装置"}, {Name: "figure2.png", Data: fig2}}
+
+	tmpl := &DocTemplate{Sections: []Section{{Body: body()}}}
+	PlaceFigures(tmpl, figs[:1])
+	got := tmpl.Sections[0].Body
+	if !bytes.Equal(got[0].Image.Data, logo) {
+		t.Error("データのない画像ブロックがあるのにロゴを置き換えました")
+	}
+	if !bytes.Equal(got[2].Image.Data, fig1) || runsText(got[3].Runs) != "図1 実験装置" {
+		t.Errorf("図の位置 = %q %q", got[2].Image.Data, runsText(got[3].Runs))
+	}
+
+	// 図の位置が足りなければテンプレートの画像も使う
+	tmpl = &DocTemplate{Sections: []Section{{Body: body()}}}
+	PlaceFigures(tmpl, figs)
+	got = tmpl.Sections[0].Body
+	if !bytes.Equal(got[0].Image.Data, fig2) || !bytes.Equal(got[3].Image.Data, fig1) {
+		t.Errorf("画像 = %q, %q", got[0].Image.Data, got[3].Image.Data)
+	}
+}
+
+// データのない・読めない画像ブロックは書き出しで飛ばす（文書全体を失敗させない）
+func TestWriteSkipsImagesWithoutData(t *testing.T) {
+	data := testPNG(t)
+	tmpl := &DocTemplate{Sections: []Section{{Body: []Block{
+		{Kind: "image"},
+		{Kind: "image", Image: &ImageBlock{Name: "empty.png"}},
+		{Kind: "image", Image: &ImageBlock{Name: "broken.png", Data: []byte("not an image")}},
+		{Kind: "image", Image: &ImageBlock{Name: "figure1.png", Data: data}},
+		{Kind: "paragraph", Runs: []Run{{Text: "図1"}}},
+	}}}}
+	images, _ := roundTripFigures(t, tmpl)
+	if len(images) != 1 || !bytes.Equal(images[0], data) {
+		t.Fatalf("書き出した画像 %d 個, want 読める 1 個", len(images))
+	}
+}
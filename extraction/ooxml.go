@@ -0,0 +1,485 @@
+package extraction
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+)
+
+/* =======================
+   純Go実装（archive/zip + encoding/xml）
+======================= */
+
+// unioffice のライセンスがなくても読み書きできるよう、
+// DocTemplate が扱う範囲（段落・見出し・箇条書き・表・画像・リンク）だけを直接扱う。
+type ooxmlBackend struct{}
+
+func (ooxmlBackend) Name() string { return BackendOOXML }
+
+// 名前空間を問わず要素をそのまま保持する汎用ノード
+type xmlNode struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Nodes   []xmlNode  `xml:",any"`
+	Text    string     `xml:",chardata"`
+}
+
+func (n *xmlNode) attr(local string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func (n *xmlNode) child(local string) *xmlNode {
+	if n == nil {
+		return nil
+	}
+	for i := range n.Nodes {
+		if n.Nodes[i].XMLName.Local == local {
+			return &n.Nodes[i]
+		}
+	}
+	return nil
+}
+
+// 子孫要素をすべて探す
+func (n *xmlNode) find(local string) []*xmlNode {
+	var out []*xmlNode
+	for i := range n.Nodes {
+		c := &n.Nodes[i]
+		if c.XMLName.Local == local {
+			out = append(out, c)
+		}
+		out = append(out, c.find(local)...)
+	}
+	return out
+}
+
+// w:b / w:i など：要素があれば有効（w:val="0" 等は無効）
+func onOff(n *xmlNode) bool {
+	if n == nil {
+		return false
+	}
+	switch n.attr("val") {
+	case "0", "false", "off":
+		return false
+	}
+	return true
+}
+
+/* =======================
+   Word → JSON 抽出
+======================= */
+
+type ooxmlReader struct {
+	files  map[string]*zip.File
+	rels   map[string]xmlRelationship
+	result *DocTemplate
+
+	listIdx    int // 現在の箇条書きブロック（Body 内の位置、なければ -1）
+	listID     string
+	imgCounter int
+}
+
+func (ooxmlBackend) Read(path string) (*DocTemplate, error) {
+	rc, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	files := map[string]*zip.File{}
+	for _, f := range rc.File {
+		files[f.Name] = f
+	}
+
+	docXML, err := readZipPart(files, "word/document.xml")
+	if err != nil {
+		return nil, err
+	}
+
+	rels := map[string]xmlRelationship{}
+	if _, ok := files["word/_rels/document.xml.rels"]; ok {
+		relsXML, err := readZipPart(files, "word/_rels/document.xml.rels")
+		if err != nil {
+			return nil, err
+		}
+		var x xmlRelationships
+		if err := xml.Unmarshal(relsXML, &x); err != nil {
+			return nil, fmt.Errorf("document.xml.rels 解析失敗: %w", err)
+		}
+		for _, r := range x.Relationships {
+			rels[r.Id] = r
+		}
+	}
+
+	var root xmlNode
+	if err := xml.Unmarshal(docXML, &root); err != nil {
+		return nil, fmt.Errorf("document.xml 解析失敗: %w", err)
+	}
+	body := root.child("body")
+	if body == nil {
+		return nil, fmt.Errorf("document.xml に本文がありません")
+	}
+
+	r := &ooxmlReader{
+		files:      files,
+		rels:       rels,
+		result:     &DocTemplate{Type: "word"},
+		listIdx:    -1,
+		imgCounter: 1,
+	}
+	if err := r.blocks(body.Nodes); err != nil {
+		return nil, err
+	}
+	r.result.PageSettingsXML = rawSectPr(docXML) // ページ設定を保持
+	return r.result, nil
+}
+
+var (
+	// 1 パーツの展開後の大きさの上限（zip bomb 対策。.docx は利用者がアップロードしたもの）
+	MaxPartBytes int64 = 64 << 20
+	// パッケージ全体の展開後の大きさの上限
+	MaxPackageBytes int64 = 256 << 20
+)
+
+func readZipPart(files map[string]*zip.File, name string) ([]byte, error) {
+	f, ok := files[name]
+	if !ok {
+		return nil, fmt.Errorf("%s が見つかりません", name)
+	}
+	return readZipFile(f)
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	if f.UncompressedSize64 > uint64(MaxPartBytes) {
+		return nil, fmt.Errorf("%s が大きすぎます（%d バイト）", f.Name, f.UncompressedSize64)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	// ヘッダーの大きさは偽れるので読む量でも制限する
+	data, err := io.ReadAll(io.LimitReader(rc, MaxPartBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > MaxPartBytes {
+		return nil, fmt.Errorf("%s が大きすぎます", f.Name)
+	}
+	return data, nil
+}
+
+// 展開後の合計が上限を超えるパッケージは開かない（unioffice はパーツをすべて読み込むため）
+func checkPackageSize(path string) error {
+	rc, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	var total uint64
+	for _, f := range rc.File {
+		if f.UncompressedSize64 > uint64(MaxPartBytes) {
+			return fmt.Errorf("%s が大きすぎます（%d バイト）", f.Name, f.UncompressedSize64)
+		}
+		total += f.UncompressedSize64
+	}
+	if total > uint64(MaxPackageBytes) {
+		return fmt.Errorf("展開後の大きさが上限を超えています（%d バイト）", total)
+	}
+	return nil
+}
+
+// 本文の要素を文書順に処理する
+func (r *ooxmlReader) blocks(nodes []xmlNode) error {
+	for i := range nodes {
+		n := &nodes[i]
+		switch n.XMLName.Local {
+		case "p":
+			if err := r.paragraph(n); err != nil {
+				return err
+			}
+		case "tbl":
+			if err := r.table(n); err != nil {
+				return err
+			}
+		case "sdt":
+			// 目次などのコンテンツコントロール
+			if c := n.child("sdtContent"); c != nil {
+				if err := r.blocks(c.Nodes); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// 現在のセクション（なければ見出しなしで作る）
+func (r *ooxmlReader) section() *Section {
+	if len(r.result.Sections) == 0 {
+		r.result.Sections = append(r.result.Sections, Section{})
+	}
+	return &r.result.Sections[len(r.result.Sections)-1]
+}
+
+func (r *ooxmlReader) add(b Block) {
+	sec := r.section()
+	sec.Body = append(sec.Body, b)
+}
+
+func (r *ooxmlReader) endList() {
+	r.listIdx = -1
+	r.listID = ""
+}
+
+func (r *ooxmlReader) paragraph(p *xmlNode) error {
+	ppr := p.child("pPr")
+	style := ppr.child("pStyle")
+	styleID := ""
+	if style != nil {
+		styleID = style.attr("val")
+	}
+
+	numID, level := "", 0
+	if np := ppr.child("numPr"); np != nil {
+		if id := np.child("numId"); id != nil {
+			numID = id.attr("val")
+		}
+		if lv := np.child("ilvl"); lv != nil {
+			fmt.Sscan(lv.attr("val"), &level)
+		}
+	}
+	if numID == "0" { // numId 0 は番号なし
+		numID = ""
+	}
+
+	runs, images, err := r.runs(p, "")
+	if err != nil {
+		return err
+	}
+	var text strings.Builder
+	for _, run := range runs {
+		text.WriteString(run.Text)
+	}
+
+	switch {
+	case strings.TrimSpace(text.String()) == "":
+		r.endList()
+		if len(images) == 0 && len(r.result.Sections) > 0 {
+			r.add(Block{Kind: "blank_line"})
+		}
+
+	case strings.HasPrefix(styleID, "Heading"):
+		r.endList()
+		r.result.Sections = append(r.result.Sections, Section{
+			Title: &Block{Kind: "paragraph", Style: styleID, Runs: runs},
+		})
+
+	case numID != "":
+		sec := r.section()
+		if r.listIdx < 0 || r.listID != numID {
+			sec.Body = append(sec.Body, Block{Kind: "list", Indent: level})
+			r.listIdx = len(sec.Body) - 1
+			r.listID = numID
+		}
+		sec.Body[r.listIdx].Items = append(sec.Body[r.listIdx].Items, runs)
+
+	default:
+		r.endList()
+		r.add(Block{Kind: "paragraph", Style: styleID, Runs: runs})
+	}
+
+	// 段落内の画像は段落の直後に置く
+	for _, img := range images {
+		r.endList()
+		r.add(Block{Kind: "image", Image: img})
+	}
+	return nil
+}
+
+// 段落（またはハイパーリンク等の入れ物）内のRunと画像を集める
+func (r *ooxmlReader) runs(n *xmlNode, link string) ([]Run, []*ImageBlock, error) {
+	var runs []Run
+	var images []*ImageBlock
+	for i := range n.Nodes {
+		c := &n.Nodes[i]
+		switch c.XMLName.Local {
+		case "r":
+			run, imgs, err := r.run(c, link)
+			if err != nil {
+				return nil, nil, err
+			}
+			if run.Text != "" {
+				runs = append(runs, run)
+			}
+			images = append(images, imgs...)
+		case "hyperlink":
+			url := link
+			if rel, ok := r.rels[c.attr("id")]; ok {
+				url = rel.Target
+			}
+			rs, imgs, err := r.runs(c, url)
+			if err != nil {
+				return nil, nil, err
+			}
+			runs = append(runs, rs...)
+			images = append(images, imgs...)
+		case "ins", "smartTag", "fldSimple", "customXml", "sdt", "sdtContent":
+			rs, imgs, err := r.runs(c, link)
+			if err != nil {
+				return nil, nil, err
+			}
+			runs = append(runs, rs...)
+			images = append(images, imgs...)
+		}
+	}
+	return runs, images, nil
+}
+
+func (r *ooxmlReader) run(n *xmlNode, link string) (Run, []*ImageBlock, error) {
+	run := Run{Hyperlink: link}
+	if rpr := n.child("rPr"); rpr != nil {
+		run.Bold = onOff(rpr.child("b"))
+		run.Italic = onOff(rpr.child("i"))
+		if sz := rpr.child("sz"); sz != nil {
+			var half int
+			fmt.Sscan(sz.attr("val"), &half)
+			run.FontSize = half / 2 // half-points → point
+		}
+	}
+
+	var text strings.Builder
+	var images []*ImageBlock
+	for i := range n.Nodes {
+		c := &n.Nodes[i]
+		switch c.XMLName.Local {
+		case "t":
+			text.WriteString(c.Text)
+		case "tab":
+			text.WriteString("\t")
+		case "br", "cr":
+			text.WriteString("\n")
+		case "drawing":
+			for _, blip := range c.find("blip") {
+				img, err := r.image(blip.attr("embed"))
+				if err != nil {
+					return Run{}, nil, err
+				}
+				if img != nil {
+					images = append(images, img)
+				}
+			}
+		}
+	}
+	run.Text = text.String()
+	return run, images, nil
+}
+
+func (r *ooxmlReader) image(relID string) (*ImageBlock, error) {
+	rel, ok := r.rels[relID]
+	if !ok || rel.Target == "" {
+		return nil, nil
+	}
+	name := strings.TrimPrefix(rel.Target, "/")
+	if !strings.HasPrefix(rel.Target, "/") {
+		name = path.Join("word", rel.Target)
+	}
+	// 外部リンク画像（パッケージ外）は対象外
+	if _, ok := r.files[name]; !ok {
+		return nil, nil
+	}
+	data, err := readZipPart(r.files, name)
+	if err != nil {
+		return nil, err
+	}
+	img := &ImageBlock{
+		Name: fmt.Sprintf("image_%d%s", r.imgCounter, path.Ext(name)),
+		Data: data,
+	}
+	r.imgCounter++
+	return img, nil
+}
+
+func (r *ooxmlReader) table(tbl *xmlNode) error {
+	r.endList()
+	tableBlock := Block{Kind: "table"}
+	for i := range tbl.Nodes {
+		tr := &tbl.Nodes[i]
+		if tr.XMLName.Local != "tr" {
+			continue
+		}
+		var rowBlocks []Block
+		for j := range tr.Nodes {
+			tc := &tr.Nodes[j]
+			if tc.XMLName.Local != "tc" {
+				continue
+			}
+			for k := range tc.Nodes {
+				p := &tc.Nodes[k]
+				if p.XMLName.Local != "p" {
+					continue
+				}
+				runs, _, err := r.runs(p, "")
+				if err != nil {
+					return err
+				}
+				b := Block{Kind: "paragraph", Runs: runs}
+				if s := p.child("pPr").child("pStyle"); s != nil {
+					b.Style = s.attr("val")
+				}
+				rowBlocks = append(rowBlocks, b)
+			}
+		}
+		tableBlock.Rows = append(tableBlock.Rows, rowBlocks)
+	}
+	r.add(tableBlock)
+	return nil
+}
+
+// ヘッダー・フッターは複製しないので参照を外す
+var headerFooterRef = regexp.MustCompile(`<w:(headerReference|footerReference)\b[^>]*/>`)
+
+// body 直下の w:sectPr を元の XML のまま取り出す
+func rawSectPr(docXML []byte) []byte {
+	d := xml.NewDecoder(bytes.NewReader(docXML))
+	depth := 0
+	for {
+		start := d.InputOffset()
+		tok, err := d.Token()
+		if err != nil {
+			return nil
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			// document > body > sectPr
+			if depth == 3 && t.Name.Local == "sectPr" {
+				if err := d.Skip(); err != nil {
+					return nil
+				}
+				raw := docXML[start:d.InputOffset()]
+				if !isSectPr(raw) {
+					return nil
+				}
+				return headerFooterRef.ReplaceAll(raw, nil)
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+}
+
+// 書き出し側と同じ w: 接頭辞のものだけ使う
+func isSectPr(raw []byte) bool {
+	return bytes.HasPrefix(raw, []byte("<w:sectPr"))
+}
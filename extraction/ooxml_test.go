@@ -0,0 +1,118 @@
+package extraction
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadRejectsOversizedParts(t *testing.T) {
+	defer func(part, pkg int64) { MaxPartBytes, MaxPackageBytes = part, pkg }(MaxPartBytes, MaxPackageBytes)
+	MaxPartBytes, MaxPackageBytes = 1<<10, 4<<10
+
+	write := func(t *testing.T, sizes ...int) string {
+		t.Helper()
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for i, n := range sizes {
+			name := "word/document.xml"
+			if i > 0 {
+				name = filepath.Join("word/media", strings.Repeat("x", i)+".bin")
+			}
+			w, err := zw.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write(bytes.Repeat([]byte(" "), n))
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), "bomb.docx")
+		if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name  string
+		sizes []int
+	}{
+		{"1 パーツが大きすぎる", []int{2 << 10}},
+		{"合計が大きすぎる", []int{1 << 10, 1 << 10, 1 << 10, 1 << 10, 1 << 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ExtractWordStructure(context.Background(), write(t, tt.sizes...))
+			if err == nil || !strings.Contains(err.Error(), "大き") {
+				t.Fatalf("err = %v, want 大きすぎる", err)
+			}
+		})
+	}
+
+	// ヘッダーの大きさを小さく偽ったパーツも読み込みで止まる
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("word/document.xml")
+	w.Write(bytes.Repeat([]byte(" "), 2<<10))
+	zw.Close()
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := zr.File[0]
+	f.UncompressedSize64 = 10
+	if _, err := readZipFile(f); err == nil {
+		t.Fatal("偽ったパーツを読み込めてしまいました")
+	}
+}
+
+// JSON を経由した文書（Gemini の出力）でも、テンプレートの用紙設定を引き継げる
+func TestPageSettingsSurviveJSON(t *testing.T) {
+	const sectPr = `<w:sectPr><w:pgSz w:w="11906" w:h="16838"/>` +
+		`<w:pgMar w:top="1985" w:right="1701" w:bottom="1701" w:left="1701"/>` +
+		`<w:cols w:num="2" w:space="425"/><w:docGrid w:linePitch="360"/></w:sectPr>`
+	roundTrip := func(t *testing.T, tmpl *DocTemplate) *DocTemplate {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), "out.docx")
+		if err := (ooxmlBackend{}).Write(tmpl, path); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		got, err := (ooxmlBackend{}).Read(path)
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		return got
+	}
+
+	src := roundTrip(t, &DocTemplate{
+		Type:            "word",
+		Sections:        []Section{{Body: []Block{{Kind: "paragraph", Runs: []Run{{Text: "本文"}}}}}},
+		PageSettingsXML: []byte(sectPr),
+	})
+	cpl, lpc, cols := pageLayout(src)
+	if cols != 2 || cpl == defaultCharsPerLine {
+		t.Fatalf("テンプレートの用紙設定を読めていません: %d 字 × %d 行 × %d 段", cpl, lpc, cols)
+	}
+
+	raw, err := json.Marshal(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gen DocTemplate
+	if err := json.Unmarshal(raw, &gen); err != nil {
+		t.Fatal(err)
+	}
+	gen.SetPage(src.Page())
+
+	got := roundTrip(t, &gen)
+	if c, l, n := pageLayout(got); c != cpl || l != lpc || n != cols {
+		t.Errorf("書き出した文書の用紙設定 = %d 字 × %d 行 × %d 段, want %d × %d × %d", c, l, n, cpl, lpc, cols)
+	}
+}
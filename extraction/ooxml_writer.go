@@ -0,0 +1,364 @@
+package extraction
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go_project/logging"
+)
+
+/* =======================
+   JSON → Word 再構築（純Go実装）
+======================= */
+
+const (
+	nsW   = "http://schemas.openxmlformats.org/wordprocessingml/2006/main"
+	nsR   = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+	nsWP  = "http://schemas.openxmlformats.org/drawingml/2006/wordprocessingDrawing"
+	nsA   = "http://schemas.openxmlformats.org/drawingml/2006/main"
+	nsPic = "http://schemas.openxmlformats.org/drawingml/2006/picture"
+
+	relTypeBase      = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/"
+	relTypeStyles    = relTypeBase + "styles"
+	relTypeNumbering = relTypeBase + "numbering"
+	relTypeHyperlink = relTypeBase + "hyperlink"
+	relTypeImage     = relTypeBase + "image"
+
+	emuPerPixel = 9525    // 96dpi
+	maxImageEMU = 5400000 // A4・既定余白の本文幅に収める
+	textWidth   = 8504    // 本文幅（twip）
+)
+
+// 用紙設定がないときの既定（A4縦・Word 日本語版の標準余白）
+const defaultSectPr = `<w:sectPr><w:pgSz w:w="11906" w:h="16838"/>` +
+	`<w:pgMar w:top="1985" w:right="1701" w:bottom="1701" w:left="1701" w:header="851" w:footer="992" w:gutter="0"/></w:sectPr>`
+
+type mediaPart struct {
+	name string // word/ からの相対パス
+	data []byte
+}
+
+type ooxmlWriter struct {
+	body      strings.Builder
+	rels      []xmlRelationship
+	external  map[string]bool // TargetMode="External" の r:id
+	media     []mediaPart
+	imageExts map[string]string
+	drawingID int
+	lastTable bool
+}
+
+func (ooxmlBackend) Write(template *DocTemplate, outputPath string) error {
+	w := &ooxmlWriter{
+		external:  map[string]bool{},
+		imageExts: map[string]string{},
+	}
+	w.addRel(relTypeStyles, "styles.xml")
+	w.addRel(relTypeNumbering, "numbering.xml")
+
+	for _, sec := range template.Sections {
+		if sec.Title != nil {
+			w.paragraph(sec.Title.Style, -1, sec.Title.Runs)
+		}
+		for _, b := range sec.Body {
+			switch b.Kind {
+			case "blank_line":
+				w.paragraph("", -1, nil)
+			case "paragraph":
+				w.paragraph(b.Style, -1, b.Runs)
+			case "list":
+				for _, item := range b.Items {
+					w.paragraph("", b.Indent, item)
+				}
+			case "table":
+				w.table(b.Rows)
+			case "image":
+				if b.Image != nil {
+					w.image(b.Image)
+				}
+			}
+		}
+	}
+
+	sectPr := defaultSectPr
+	if isSectPr(template.PageSettingsXML) {
+		sectPr = string(template.PageSettingsXML)
+	}
+	return w.save(outputPath, sectPr)
+}
+
+func (w *ooxmlWriter) addRel(typ, target string) string {
+	id := fmt.Sprintf("rId%d", len(w.rels)+1)
+	w.rels = append(w.rels, xmlRelationship{Id: id, Type: typ, Target: target})
+	return id
+}
+
+func escapeXML(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// level >= 0 なら箇条書きの項目
+func (w *ooxmlWriter) paragraph(style string, level int, runs []Run) {
+	w.lastTable = false
+	w.body.WriteString("<w:p>")
+	if style != "" || level >= 0 {
+		w.body.WriteString("<w:pPr>")
+		if style != "" {
+			fmt.Fprintf(&w.body, `<w:pStyle w:val="%s"/>`, escapeXML(style))
+		}
+		if level >= 0 {
+			if level > 8 {
+				level = 8
+			}
+			fmt.Fprintf(&w.body, `<w:numPr><w:ilvl w:val="%d"/><w:numId w:val="1"/></w:numPr>`, level)
+		}
+		w.body.WriteString("</w:pPr>")
+	}
+	w.runs(runs)
+	w.body.WriteString("</w:p>")
+}
+
+func (w *ooxmlWriter) runs(runs []Run) {
+	for _, r := range runs {
+		if r.Hyperlink != "" {
+			id := w.addRel(relTypeHyperlink, r.Hyperlink)
+			w.external[id] = true
+			fmt.Fprintf(&w.body, `<w:hyperlink r:id="%s">`, id)
+			w.run(r, true)
+			w.body.WriteString("</w:hyperlink>")
+			continue
+		}
+		w.run(r, false)
+	}
+}
+
+func (w *ooxmlWriter) run(r Run, link bool) {
+	w.body.WriteString("<w:r>")
+	if link || r.Bold || r.Italic || r.FontSize > 0 {
+		w.body.WriteString("<w:rPr>")
+		if link {
+			w.body.WriteString(`<w:rStyle w:val="Hyperlink"/>`)
+		}
+		if r.Bold {
+			w.body.WriteString("<w:b/><w:bCs/>")
+		}
+		if r.Italic {
+			w.body.WriteString("<w:i/><w:iCs/>")
+		}
+		if r.FontSize > 0 {
+			fmt.Fprintf(&w.body, `<w:sz w:val="%d"/><w:szCs w:val="%d"/>`, r.FontSize*2, r.FontSize*2)
+		}
+		w.body.WriteString("</w:rPr>")
+	}
+
+	// タブ・改行は専用の要素に分ける
+	for i, line := range strings.Split(r.Text, "\n") {
+		if i > 0 {
+			w.body.WriteString("<w:br/>")
+		}
+		for j, part := range strings.Split(line, "\t") {
+			if j > 0 {
+				w.body.WriteString("<w:tab/>")
+			}
+			if part != "" {
+				fmt.Fprintf(&w.body, `<w:t xml:space="preserve">%s</w:t>`, escapeXML(part))
+			}
+		}
+	}
+	w.body.WriteString("</w:r>")
+}
+
+func (w *ooxmlWriter) table(rows [][]Block) {
+	cols := 0
+	for _, row := range rows {
+		if len(row) > cols {
+			cols = len(row)
+		}
+	}
+	if cols == 0 {
+		return
+	}
+	colWidth := textWidth / cols
+
+	w.body.WriteString(`<w:tbl><w:tblPr><w:tblStyle w:val="TableGrid"/><w:tblW w:w="0" w:type="auto"/><w:tblLook w:val="04A0"/></w:tblPr><w:tblGrid>`)
+	for i := 0; i < cols; i++ {
+		fmt.Fprintf(&w.body, `<w:gridCol w:w="%d"/>`, colWidth)
+	}
+	w.body.WriteString("</w:tblGrid>")
+	for _, row := range rows {
+		if len(row) == 0 {
+			continue
+		}
+		w.body.WriteString("<w:tr>")
+		for _, cell := range row {
+			fmt.Fprintf(&w.body, `<w:tc><w:tcPr><w:tcW w:w="%d" w:type="dxa"/></w:tcPr>`, colWidth)
+			w.paragraph(cell.Style, -1, cell.Runs)
+			w.body.WriteString("</w:tc>")
+		}
+		w.body.WriteString("</w:tr>")
+	}
+	w.body.WriteString("</w:tbl>")
+	w.lastTable = true
+}
+
+// データのない画像（差し込まれなかった図の位置）や読めない画像は飛ばす
+func (w *ooxmlWriter) image(img *ImageBlock) {
+	if len(img.Data) == 0 {
+		return
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(img.Data))
+	if err != nil {
+		slog.Warn("画像を読み込めないため省きます", "name", img.Name, logging.Err(err))
+		return
+	}
+	w.imageExts[format] = "image/" + format
+
+	w.drawingID++
+	name := fmt.Sprintf("media/image%d.%s", w.drawingID, format)
+	w.media = append(w.media, mediaPart{name: name, data: img.Data})
+	id := w.addRel(relTypeImage, name)
+
+	// ピクセル → EMU（本文幅を超える場合は縮小）
+	cx, cy := int64(cfg.Width)*emuPerPixel, int64(cfg.Height)*emuPerPixel
+	if cx > maxImageEMU {
+		cy = cy * maxImageEMU / cx
+		cx = maxImageEMU
+	}
+
+	w.lastTable = false
+	fmt.Fprintf(&w.body, `<w:p><w:r><w:drawing><wp:inline distT="0" distB="0" distL="0" distR="0">`+
+		`<wp:extent cx="%[1]d" cy="%[2]d"/><wp:docPr id="%[3]d" name="Picture %[3]d"/>`+
+		`<wp:cNvGraphicFramePr><a:graphicFrameLocks noChangeAspect="1"/></wp:cNvGraphicFramePr>`+
+		`<a:graphic><a:graphicData uri="%[5]s"><pic:pic>`+
+		`<pic:nvPicPr><pic:cNvPr id="%[3]d" name="%[4]s"/><pic:cNvPicPr/></pic:nvPicPr>`+
+		`<pic:blipFill><a:blip r:embed="%[6]s"/><a:stretch><a:fillRect/></a:stretch></pic:blipFill>`+
+		`<pic:spPr><a:xfrm><a:off x="0" y="0"/><a:ext cx="%[1]d" cy="%[2]d"/></a:xfrm><a:prstGeom prst="rect"><a:avLst/></a:prstGeom></pic:spPr>`+
+		`</pic:pic></a:graphicData></a:graphic></wp:inline></w:drawing></w:r></w:p>`,
+		cx, cy, w.drawingID, escapeXML(img.Name), nsPic, id)
+}
+
+func (w *ooxmlWriter) save(outputPath, sectPr string) error {
+	// 表で終わる文書は Word が修復を求めるので段落を足す
+	if w.lastTable {
+		w.body.WriteString("<w:p/>")
+	}
+
+	var doc strings.Builder
+	doc.WriteString(xml.Header)
+	fmt.Fprintf(&doc, `<w:document xmlns:w="%s" xmlns:r="%s" xmlns:wp="%s" xmlns:a="%s" xmlns:pic="%s"><w:body>`,
+		nsW, nsR, nsWP, nsA, nsPic)
+	doc.WriteString(w.body.String())
+	doc.WriteString(sectPr)
+	doc.WriteString("</w:body></w:document>")
+
+	var rels strings.Builder
+	rels.WriteString(xml.Header)
+	rels.WriteString(`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for _, r := range w.rels {
+		mode := ""
+		if w.external[r.Id] {
+			mode = ` TargetMode="External"`
+		}
+		fmt.Fprintf(&rels, `<Relationship Id="%s" Type="%s" Target="%s"%s/>`, r.Id, r.Type, escapeXML(r.Target), mode)
+	}
+	rels.WriteString("</Relationships>")
+
+	var types strings.Builder
+	types.WriteString(xml.Header)
+	types.WriteString(`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`)
+	types.WriteString(`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`)
+	types.WriteString(`<Default Extension="xml" ContentType="application/xml"/>`)
+	for ext, ct := range w.imageExts {
+		fmt.Fprintf(&types, `<Default Extension="%s" ContentType="%s"/>`, ext, ct)
+	}
+	types.WriteString(`<Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/>`)
+	types.WriteString(`<Override PartName="/word/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.styles+xml"/>`)
+	types.WriteString(`<Override PartName="/word/numbering.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.numbering+xml"/>`)
+	types.WriteString("</Types>")
+
+	parts := []mediaPart{
+		{"[Content_Types].xml", []byte(types.String())},
+		{"_rels/.rels", []byte(xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="` + relTypeBase + `officeDocument" Target="word/document.xml"/></Relationships>`)},
+		{"word/document.xml", []byte(doc.String())},
+		{"word/_rels/document.xml.rels", []byte(rels.String())},
+		{"word/styles.xml", []byte(stylesXML())},
+		{"word/numbering.xml", []byte(numberingXML())},
+	}
+	for _, m := range w.media {
+		parts = append(parts, mediaPart{"word/" + m.name, m.data})
+	}
+
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(f)
+	for _, p := range parts {
+		pw, err := zw.Create(p.name)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if _, err := pw.Write(p.data); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// 見出し・表・リンク用の最低限のスタイル
+func stylesXML() string {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	fmt.Fprintf(&b, `<w:styles xmlns:w="%s">`, nsW)
+	b.WriteString(`<w:docDefaults><w:rPrDefault><w:rPr><w:rFonts w:ascii="Century" w:eastAsia="游明朝" w:hAnsi="Century" w:cs="Times New Roman"/>` +
+		`<w:kern w:val="2"/><w:sz w:val="21"/><w:szCs w:val="22"/><w:lang w:val="en-US" w:eastAsia="ja-JP"/></w:rPr></w:rPrDefault>` +
+		`<w:pPrDefault><w:pPr><w:jc w:val="both"/></w:pPr></w:pPrDefault></w:docDefaults>`)
+	b.WriteString(`<w:style w:type="paragraph" w:default="1" w:styleId="Normal"><w:name w:val="Normal"/><w:qFormat/></w:style>`)
+	b.WriteString(`<w:style w:type="paragraph" w:styleId="Title"><w:name w:val="Title"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:qFormat/>` +
+		`<w:pPr><w:spacing w:before="240" w:after="120"/><w:jc w:val="center"/><w:outlineLvl w:val="0"/></w:pPr><w:rPr><w:b/><w:sz w:val="32"/><w:szCs w:val="32"/></w:rPr></w:style>`)
+	sizes := []int{32, 28, 24, 22, 21, 21}
+	for i, sz := range sizes {
+		fmt.Fprintf(&b, `<w:style w:type="paragraph" w:styleId="Heading%[1]d"><w:name w:val="heading %[1]d"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:qFormat/>`+
+			`<w:pPr><w:keepNext/><w:spacing w:before="240" w:after="60"/><w:outlineLvl w:val="%[2]d"/></w:pPr><w:rPr><w:b/><w:sz w:val="%[3]d"/><w:szCs w:val="%[3]d"/></w:rPr></w:style>`,
+			i+1, i, sz)
+	}
+	b.WriteString(`<w:style w:type="character" w:styleId="Hyperlink"><w:name w:val="Hyperlink"/><w:rPr><w:color w:val="0563C1"/><w:u w:val="single"/></w:rPr></w:style>`)
+	b.WriteString(`<w:style w:type="table" w:styleId="TableGrid"><w:name w:val="Table Grid"/><w:tblPr><w:tblBorders>` +
+		`<w:top w:val="single" w:sz="4" w:space="0" w:color="auto"/><w:left w:val="single" w:sz="4" w:space="0" w:color="auto"/>` +
+		`<w:bottom w:val="single" w:sz="4" w:space="0" w:color="auto"/><w:right w:val="single" w:sz="4" w:space="0" w:color="auto"/>` +
+		`<w:insideH w:val="single" w:sz="4" w:space="0" w:color="auto"/><w:insideV w:val="single" w:sz="4" w:space="0" w:color="auto"/>` +
+		`</w:tblBorders><w:tblCellMar><w:left w:w="108" w:type="dxa"/><w:right w:w="108" w:type="dxa"/></w:tblCellMar></w:tblPr></w:style>`)
+	b.WriteString("</w:styles>")
+	return b.String()
+}
+
+// 箇条書き定義（unioffice 実装と同じ「•」、レベルごとに字下げ）
+func numberingXML() string {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	fmt.Fprintf(&b, `<w:numbering xmlns:w="%s"><w:abstractNum w:abstractNumId="0"><w:multiLevelType w:val="hybridMultilevel"/>`, nsW)
+	for lvl := 0; lvl < 9; lvl++ {
+		fmt.Fprintf(&b, `<w:lvl w:ilvl="%d"><w:start w:val="1"/><w:numFmt w:val="bullet"/><w:lvlText w:val="•"/><w:lvlJc w:val="left"/>`+
+			`<w:pPr><w:ind w:left="%d" w:hanging="360"/></w:pPr></w:lvl>`, lvl, 720*(lvl+1))
+	}
+	b.WriteString(`</w:abstractNum><w:num w:numId="1"><w:abstractNumId w:val="0"/></w:num></w:numbering>`)
+	return b.String()
+}
@@ -0,0 +1,265 @@
+package extraction
+
+import (
+	"encoding/xml"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/* =======================
+   文書の概要（結果カード用）
+======================= */
+
+// 用紙設定がないときの 1 ページの量（A4・40字×30行）
+const (
+	defaultCharsPerLine = 40
+	defaultLinesPerPage = 30
+)
+
+type Summary struct {
+	Title      string // Title スタイルの段落 → 最初の見出し → 冒頭で最も大きい文字の段落
+	Characters int    // 空白を除く文字数
+	Pages      int    // 目安（レンダリングはしないので実際とは異なる）
+}
+
+func Summarize(t *DocTemplate) Summary {
+	var s Summary
+	var title, heading string
+	lines := 0
+	cpl, lpc, cols := pageLayout(t)
+	lpp := lpc * cols
+
+	// b の行数（表はセルの最大行数を行ごとに足す）
+	var visit func(b Block) int
+	visit = func(b Block) int {
+		switch b.Kind {
+		case "paragraph":
+			text := runsText(b.Runs)
+			if title == "" && b.Style == "Title" {
+				title = text
+			}
+			s.Characters += countChars(text)
+			return lineCount(text, cpl)
+		case "list":
+			n := 0
+			for _, item := range b.Items {
+				text := runsText(item)
+				s.Characters += countChars(text)
+				n += lineCount(text, cpl)
+			}
+			return n
+		case "table":
+			n := 0
+			for _, row := range b.Rows {
+				tallest := 1
+				for _, cell := range row {
+					tallest = max(tallest, visit(cell))
+				}
+				n += tallest
+			}
+			return n
+		case "image":
+			return lpc / 6 // 段の 1/6 程度
+		}
+		return 1
+	}
+
+	for _, sec := range t.Sections {
+		if sec.Title != nil {
+			text := runsText(sec.Title.Runs)
+			if title == "" && sec.Title.Style == "Title" {
+				title = text
+			}
+			if heading == "" && text != "" {
+				heading = text
+			}
+			s.Characters += countChars(text)
+			lines += 2
+		}
+		for _, b := range sec.Body {
+			lines += visit(b)
+		}
+	}
+
+	switch {
+	case title != "":
+		s.Title = title
+	case heading != "":
+		s.Title = heading
+	case len(t.Sections) > 0:
+		s.Title = largestParagraph(t.Sections[0].Body)
+	}
+	s.Pages = max(1, (lines+lpp-1)/lpp)
+	return s
+}
+
+// 見出しスタイルのない文書の表題：冒頭の段落のうち文字が一番大きいもの（同じ大きさが並ぶなら諦める）
+func largestParagraph(blocks []Block) string {
+	best, bestSize, tie := "", 0, false
+	for _, b := range blocks[:min(len(blocks), 10)] {
+		if b.Kind != "paragraph" {
+			continue
+		}
+		text := runsText(b.Runs)
+		if text == "" {
+			continue
+		}
+		size := 0
+		for _, r := range b.Runs {
+			size = max(size, r.FontSize)
+		}
+		switch {
+		case size > bestSize:
+			best, bestSize, tie = text, size, false
+		case size == bestSize:
+			tie = true
+		}
+	}
+	if tie || bestSize == 0 {
+		return ""
+	}
+	return best
+}
+
+// 本文のテキスト（段落・箇条書きの項目・表のセルごとに改行）
+func PlainText(t *DocTemplate) string {
+	var sb strings.Builder
+	line := func(s string) {
+		if s != "" {
+			sb.WriteString(s)
+			sb.WriteByte('\n')
+		}
+	}
+	var visit func(b Block)
+	visit = func(b Block) {
+		line(runsText(b.Runs))
+		for _, item := range b.Items {
+			line(runsText(item))
+		}
+		for _, row := range b.Rows {
+			for _, cell := range row {
+				visit(cell)
+			}
+		}
+	}
+	for _, sec := range t.Sections {
+		if sec.Title != nil {
+			line(runsText(sec.Title.Runs))
+		}
+		for _, b := range sec.Body {
+			visit(b)
+		}
+	}
+	return sb.String()
+}
+
+// 見出しの文字列（見出しのないセクションは「本文」）
+func (s Section) Heading() string {
+	if s.Title != nil {
+		if text := runsText(s.Title.Runs); text != "" {
+			return text
+		}
+	}
+	return "本文"
+}
+
+func runsText(runs []Run) string {
+	var sb strings.Builder
+	for _, r := range runs {
+		sb.WriteString(r.Text)
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+func countChars(s string) int {
+	n := 0
+	for _, r := range s {
+		if !unicode.IsSpace(r) {
+			n++
+		}
+	}
+	return n
+}
+
+// 空の段落も 1 行
+func lineCount(s string, charsPerLine int) int {
+	return max(1, (utf8.RuneCountInString(s)+charsPerLine-1)/charsPerLine)
+}
+
+// w:sectPr の寸法（twip）
+type sectLayout struct {
+	PgSz struct {
+		W int `xml:"w,attr"`
+		H int `xml:"h,attr"`
+	} `xml:"pgSz"`
+	PgMar struct {
+		Top    int `xml:"top,attr"`
+		Right  int `xml:"right,attr"`
+		Bottom int `xml:"bottom,attr"`
+		Left   int `xml:"left,attr"`
+	} `xml:"pgMar"`
+	Cols struct {
+		Num   int `xml:"num,attr"`
+		Space int `xml:"space,attr"`
+	} `xml:"cols"`
+	DocGrid struct {
+		LinePitch int `xml:"linePitch,attr"`
+	} `xml:"docGrid"`
+}
+
+// 1 行の文字数・1 段の行数・段数
+func pageLayout(t *DocTemplate) (charsPerLine, linesPerColumn, cols int) {
+	var l sectLayout
+	raw := t.PageSettingsXML
+	if len(raw) == 0 && t.PageSettings != nil {
+		// unioffice で読んだテンプレート（要素名だけで読むので名前空間は問わない）
+		raw, _ = xml.Marshal(t.PageSettings)
+	}
+	if len(raw) == 0 || xml.Unmarshal(raw, &l) != nil {
+		return defaultCharsPerLine, defaultLinesPerPage, 1
+	}
+	width := l.PgSz.W - l.PgMar.Left - l.PgMar.Right
+	height := l.PgSz.H - l.PgMar.Top - l.PgMar.Bottom
+	if width <= 0 || height <= 0 {
+		return defaultCharsPerLine, defaultLinesPerPage, 1
+	}
+
+	cols = max(1, l.Cols.Num)
+	colWidth := (width - l.Cols.Space*(cols-1)) / cols
+
+	font := bodyFontSize(t) * 20 // pt → twip
+	pitch := l.DocGrid.LinePitch
+	if pitch <= 0 {
+		pitch = font * 3 / 2
+	}
+	return max(1, colWidth/font), max(1, height/pitch), cols
+}
+
+// 本文で最も多く使われている文字の大きさ（pt、指定がなければ 10.5 の切り捨て）
+func bodyFontSize(t *DocTemplate) int {
+	count := map[int]int{}
+	var add func(runs []Run)
+	add = func(runs []Run) {
+		for _, r := range runs {
+			if r.FontSize > 0 {
+				count[r.FontSize] += utf8.RuneCountInString(r.Text)
+			}
+		}
+	}
+	for _, sec := range t.Sections {
+		for _, b := range sec.Body {
+			add(b.Runs)
+			for _, item := range b.Items {
+				add(item)
+			}
+		}
+	}
+	size, most := 10, 0
+	for s, n := range count {
+		if n > most || (n == most && s < size) {
+			size, most = s, n
+		}
+	}
+	return size
+}
@@ -5,16 +5,33 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"go_project/config"
 	"go_project/extraction"
-	"log"
+	"go_project/logging"
+	"go_project/metrics"
+	"go_project/tracing"
+	"log/slog"
+	"net/http"
 	"os"
 	"regexp"
 	"strings"
+	"time"
 
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/genai"
 )
 
-var apiKey=os.Getenv("GEMINI_API_KEY")
+var cfg config.Gemini
+
+// Gemini API への HTTP 呼び出しもトレースに載せる
+var httpClient = &http.Client{Transport: tracing.Transport(nil)}
+
+func Configure(c config.Gemini) {
+	cfg = c
+}
+
+// 使用モデル（生成文書のメタデータにも記録する）
+const Model = "gemini-2.5-flash"
 
 func cleanJSONFromText(s string) (string, error) {
     // よくあるパターン：```json ... ``` を取り除く
@@ -45,17 +62,67 @@ func cleanJSONFromText(s string) (string, error) {
 }
 
 
-func ChatAiSystem(incomingText string) (string, error) {
-	ctx := context.Background()
+// APIキーが有効で使用モデルを参照できるか確かめる
+func Ping(ctx context.Context) error {
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{
+		APIKey:     cfg.APIKey.Value(),
+		Backend:    genai.BackendGeminiAPI,
+		HTTPClient: httpClient,
+	})
+	if err != nil {
+		return fmt.Errorf("Gemini初期化失敗: %v", err)
+	}
+	_, err = client.Models.Get(ctx, Model, nil)
+	return err
+}
+
+// 生成用プロンプトファイルが読めて空でないか
+func CheckPrompt() error {
+	b, err := os.ReadFile(cfg.PromptPath)
+	if err != nil {
+		return fmt.Errorf("prompt.txt読み込み失敗: %v", err)
+	}
+	if len(strings.TrimSpace(string(b))) == 0 {
+		return fmt.Errorf("%s が空です", cfg.PromptPath)
+	}
+	return nil
+}
+
+// 文書をジョブごとの一時ファイルに書き出す（同時に動く生成・修正で上書きし合わないように）
+func writeDocx(ctx context.Context, doc *extraction.DocTemplate) (string, error) {
+	f, err := os.CreateTemp("", "output-*.docx")
+	if err != nil {
+		return "", err
+	}
+	path := f.Name()
+	f.Close()
+	if err := extraction.ApplyJSONToWordStruct(ctx, doc, path); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// 入力・出力トークン数（取得できなければ 0）
+func usage(res *genai.GenerateContentResponse) (prompt, candidates int) {
+	if res == nil || res.UsageMetadata == nil {
+		return 0, 0
+	}
+	return int(res.UsageMetadata.PromptTokenCount), int(res.UsageMetadata.CandidatesTokenCount)
+}
+
+func ChatAiSystem(ctx context.Context, incomingText string) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "gemini.chat", attribute.String("gen_ai.request.model", Model))
+	defer func() { tracing.End(span, err) }()
 
-	
 	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-        APIKey:  apiKey,
-        Backend: genai.BackendGeminiAPI,
+        APIKey:     cfg.APIKey.Value(),
+        Backend:    genai.BackendGeminiAPI,
+        HTTPClient: httpClient,
     })
 
 	if err != nil {
-		log.Fatal(err)
+		return "", fmt.Errorf("Gemini初期化失敗: %v", err)
 	}
 
 	// 🔹 system 相当の指示は「最初の user メッセージ」として入れる
@@ -67,7 +134,7 @@ func ChatAiSystem(incomingText string) (string, error) {
 
 	chat, err := client.Chats.Create(
 		ctx,
-		"gemini-2.5-flash",
+		Model,
 		nil,      // ← Config は nil
 		history,  // ← ここに system 指示を含める
 	)
@@ -75,13 +142,20 @@ func ChatAiSystem(incomingText string) (string, error) {
 		return "初期化失敗", err
 	}
 
+	start := time.Now()
 	res, err := chat.SendMessage(
 		ctx,
 		genai.Part{Text: incomingText},
 	)
+	pt, ct := usage(res)
+	metrics.Gemini(Model, "chat", start, pt, ct, err)
+	span.SetAttributes(attribute.Int("gen_ai.usage.input_tokens", pt), attribute.Int("gen_ai.usage.output_tokens", ct))
 	if err != nil {
+		slog.ErrorContext(ctx, "Gemini 会話失敗", "model", Model, logging.Err(err))
 		return "生成失敗", err
 	}
+	slog.InfoContext(ctx, "Gemini 会話", "model", Model, "duration_ms", time.Since(start).Milliseconds(),
+		logging.Text("input", incomingText))
 
 	if len(res.Candidates) > 0 &&
 		len(res.Candidates[0].Content.Parts) > 0 {
@@ -93,57 +167,78 @@ func ChatAiSystem(incomingText string) (string, error) {
 
 
 
-func GenerateAiSystem(templateJSON string, researchText string) (string, error) {
-	ctx := context.Background()
+// page はテンプレートの用紙設定（JSON に含まれないので別に渡す）。
+// figures はユーザーが送った図。文書の画像ブロックに順に差し込む（余れば末尾に足す）。
+// 書き出した .docx はジョブごとの一時ファイルなので、使い終わったら呼び出し側で消す。
+func GenerateAiSystem(ctx context.Context, templateJSON string, page extraction.PageSettings, researchText string, figures []extraction.Figure) (_ string, _ *extraction.DocTemplate, err error) {
+	ctx, span := tracing.Start(ctx, "gemini.generate", attribute.String("gen_ai.request.model", Model))
+	defer func() { tracing.End(span, err) }()
 
 	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-        APIKey:  apiKey,
-        Backend: genai.BackendGeminiAPI,
+        APIKey:     cfg.APIKey.Value(),
+        Backend:    genai.BackendGeminiAPI,
+        HTTPClient: httpClient,
     })
 	
 	if err != nil {
-		return "", fmt.Errorf("Gemini初期化失敗: %v", err)
+		return "", nil, fmt.Errorf("Gemini初期化失敗: %v", err)
 	}
 
 	// prompt.txtを読み込む
-	systemPromptBytes, err := os.ReadFile("prompt.txt")
+	systemPromptBytes, err := os.ReadFile(cfg.PromptPath)
 	if err != nil {
-		return "", fmt.Errorf("prompt.txt読み込み失敗: %v", err)
+		return "", nil, fmt.Errorf("prompt.txt読み込み失敗: %v", err)
 	}
 	systemPrompt := string(systemPromptBytes)
 
-	chat, err := client.Chats.Create(ctx, "gemini-2.5-flash", nil, []*genai.Content{
+	chat, err := client.Chats.Create(ctx, Model, nil, []*genai.Content{
 		genai.NewContentFromText(systemPrompt, "user"),
 	})
 	if err != nil {
-		return "初期化失敗", err
+		return "初期化失敗", nil, err
 	}
 
 	userPrompt := "【構造テンプレートJSON】\n" + templateJSON + "\n【新しい研究内容】\n" + researchText
+	if len(figures) > 0 {
+		userPrompt += "\n【図】（画像は後で差し込むので、図の位置に {\"kind\":\"image\"} のブロックを図1から順に置き、本文では図番号で参照する）\n"
+		for i, f := range figures {
+			userPrompt += f.Label(i+1) + "\n"
+		}
+	}
 
+	start := time.Now()
 	res, err := chat.SendMessage(ctx, genai.Part{Text: userPrompt})
+	pt, ct := usage(res)
+	metrics.Gemini(Model, "generate", start, pt, ct, err)
+	span.SetAttributes(attribute.Int("gen_ai.usage.input_tokens", pt), attribute.Int("gen_ai.usage.output_tokens", ct))
 	if err != nil {
-    	return "生成失敗", err
+		slog.ErrorContext(ctx, "Gemini 生成失敗", "model", Model, logging.Err(err))
+    	return "生成失敗", nil, err
 	}
+	slog.InfoContext(ctx, "Gemini 生成", "model", Model, "duration_ms", time.Since(start).Milliseconds(),
+		logging.Text("research", researchText), "template_bytes", len(templateJSON))
 
 	// Candidates[0] のテキストをクリーンに
 	aiRaw := res.Candidates[0].Content.Parts[0].Text
 	aiJSON, err := cleanJSONFromText(aiRaw)
 	if err != nil {
-    	log.Printf("AI生出力: %q", aiRaw)
-    	return "", fmt.Errorf("JSON抽出失敗: %w", err)
+		slog.WarnContext(ctx, "AI出力にJSONがありません", logging.Text("output", aiRaw))
+    	return "", nil, fmt.Errorf("JSON抽出失敗: %w", err)
 	}
 
 	var newTemplate extraction.DocTemplate
-	if err := json.Unmarshal([]byte(aiJSON), &newTemplate); err != nil {
-    	return "JSONパース失敗", err
+	if err = json.Unmarshal([]byte(aiJSON), &newTemplate); err != nil {
+    	return "JSONパース失敗", nil, err
 	}
 
-	outputPath := os.TempDir() + "/output.docx"
-	if err := extraction.ApplyJSONToWordStruct(&newTemplate, outputPath); err != nil {
-    	return "Word書き出し失敗", err
+	newTemplate.SetPage(page)
+	extraction.PlaceFigures(&newTemplate, figures)
+
+	outputPath, err := writeDocx(ctx, &newTemplate)
+	if err != nil {
+    	return "Word書き出し失敗", nil, err
 	}
 
-	return outputPath, nil
+	return outputPath, &newTemplate, nil
 
 }
\ No newline at end of file
@@ -0,0 +1,282 @@
+package gemini
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go_project/extraction"
+	"go_project/logging"
+	"go_project/metrics"
+	"go_project/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/genai"
+)
+
+/* =======================
+   生成済み文書の部分修正
+======================= */
+
+// 指示に当てはまる箇所がなかった
+var ErrNoRevision = errors.New("修正箇所がありません")
+
+// prompt.txt のルールに続けて渡す指示
+const revisePrompt = `
+【修正モード】
+これから渡す【文書JSON】は、上のルールで生成済みの文書です。
+各ブロックには id が付いています（"<セクション番号>-<ブロック番号>"、見出しは "<セクション番号>-title"）。
+【修正指示】に関係するブロックだけを書き直し、次の形式の JSON で返してください。
+
+{"changes":[{"id":"0-3","blocks":[ 置き換え後のブロック（0 個以上） ]}]}
+
+・指示に関係しないブロックは changes に含めない
+・blocks が空なら、そのブロックを削除する。複数なら、その位置に順に挿入する
+・ブロックの形式（kind, style, runs, items, rows, image）は文書JSONと同じ
+・image ブロックは name だけを指定する（元の文書にある name のみ使える）
+・見出し（-title）は 1 個の paragraph ブロックで置き換える
+`
+
+// Gemini に渡すブロック（画像の中身は送らない）
+type idBlock struct {
+	ID    string           `json:"id"`
+	Block extraction.Block `json:"block"`
+}
+
+type change struct {
+	ID     string             `json:"id"`
+	Blocks []extraction.Block `json:"blocks"`
+}
+
+// ReviseAiSystem は指示に関係するブロックだけを書き直した新しい版を書き出す。
+// 戻り値の []int は書き換えたセクション番号。doc は変更しない。
+// GenerateAiSystem と同じく、書き出した .docx は呼び出し側で消す。
+func ReviseAiSystem(ctx context.Context, doc *extraction.DocTemplate, instruction string) (_ string, _ *extraction.DocTemplate, _ []int, err error) {
+	ctx, span := tracing.Start(ctx, "gemini.revise", attribute.String("gen_ai.request.model", Model))
+	defer func() { tracing.End(span, err) }()
+
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{
+		APIKey:     cfg.APIKey.Value(),
+		Backend:    genai.BackendGeminiAPI,
+		HTTPClient: httpClient,
+	})
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("Gemini初期化失敗: %v", err)
+	}
+
+	systemPrompt, err := os.ReadFile(cfg.PromptPath)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("prompt.txt読み込み失敗: %v", err)
+	}
+
+	chat, err := client.Chats.Create(ctx, Model, nil, []*genai.Content{
+		genai.NewContentFromText(string(systemPrompt)+revisePrompt, "user"),
+	})
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	docJSON, err := json.Marshal(withIDs(doc))
+	if err != nil {
+		return "", nil, nil, err
+	}
+	userPrompt := "【文書JSON】\n" + string(docJSON) + "\n【修正指示】\n" + instruction
+
+	start := time.Now()
+	res, err := chat.SendMessage(ctx, genai.Part{Text: userPrompt})
+	pt, ct := usage(res)
+	metrics.Gemini(Model, "revise", start, pt, ct, err)
+	span.SetAttributes(attribute.Int("gen_ai.usage.input_tokens", pt), attribute.Int("gen_ai.usage.output_tokens", ct))
+	if err != nil {
+		slog.ErrorContext(ctx, "Gemini 修正失敗", "model", Model, logging.Err(err))
+		return "", nil, nil, err
+	}
+	slog.InfoContext(ctx, "Gemini 修正", "model", Model, "duration_ms", time.Since(start).Milliseconds(),
+		logging.Text("instruction", instruction), "doc_bytes", len(docJSON))
+
+	if len(res.Candidates) == 0 || res.Candidates[0].Content == nil || len(res.Candidates[0].Content.Parts) == 0 {
+		return "", nil, nil, errors.New("応答なし")
+	}
+	aiRaw := res.Candidates[0].Content.Parts[0].Text
+	aiJSON, err := cleanJSONFromText(aiRaw)
+	if err != nil {
+		slog.WarnContext(ctx, "AI出力にJSONがありません", logging.Text("output", aiRaw))
+		return "", nil, nil, fmt.Errorf("JSON抽出失敗: %w", err)
+	}
+	var out struct {
+		Changes []change `json:"changes"`
+	}
+	if err = json.Unmarshal([]byte(aiJSON), &out); err != nil {
+		return "", nil, nil, fmt.Errorf("JSONパース失敗: %w", err)
+	}
+
+	revised, sections := applyChanges(ctx, doc, out.Changes)
+	if len(sections) == 0 {
+		return "", nil, nil, ErrNoRevision
+	}
+	span.SetAttributes(attribute.Int("revise.sections", len(sections)))
+
+	outputPath, err := writeDocx(ctx, revised)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return outputPath, revised, sections, nil
+}
+
+// セクションごとに id 付きのブロックを並べる
+func withIDs(doc *extraction.DocTemplate) []map[string]any {
+	sections := make([]map[string]any, 0, len(doc.Sections))
+	for i, sec := range doc.Sections {
+		s := map[string]any{"section": i}
+		if sec.Title != nil {
+			s["title"] = idBlock{ID: fmt.Sprintf("%d-title", i), Block: stripImages(*sec.Title)}
+		}
+		body := make([]idBlock, 0, len(sec.Body))
+		for j, b := range sec.Body {
+			body = append(body, idBlock{ID: fmt.Sprintf("%d-%d", i, j), Block: stripImages(b)})
+		}
+		s["body"] = body
+		sections = append(sections, s)
+	}
+	return sections
+}
+
+// 画像の中身を除いたコピー
+func stripImages(b extraction.Block) extraction.Block {
+	if b.Image != nil {
+		b.Image = &extraction.ImageBlock{Name: b.Image.Name}
+	}
+	if len(b.Rows) > 0 {
+		rows := make([][]extraction.Block, len(b.Rows))
+		for i, row := range b.Rows {
+			rows[i] = make([]extraction.Block, len(row))
+			for j, cell := range row {
+				rows[i][j] = stripImages(cell)
+			}
+		}
+		b.Rows = rows
+	}
+	return b
+}
+
+// 元の文書の画像を name で戻す（知らない name の画像は捨てる）
+func restoreImages(blocks []extraction.Block, images map[string][]byte) []extraction.Block {
+	out := blocks[:0:0]
+	for _, b := range blocks {
+		if b.Kind == "image" || b.Image != nil {
+			if b.Image == nil || images[b.Image.Name] == nil {
+				continue
+			}
+			b.Image = &extraction.ImageBlock{Name: b.Image.Name, Data: images[b.Image.Name]}
+		}
+		for i, row := range b.Rows {
+			b.Rows[i] = restoreImages(row, images)
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+func collectImages(blocks []extraction.Block, images map[string][]byte) {
+	for _, b := range blocks {
+		if b.Image != nil && len(b.Image.Data) > 0 {
+			images[b.Image.Name] = b.Image.Data
+		}
+		for _, row := range b.Rows {
+			collectImages(row, images)
+		}
+	}
+}
+
+// 変更を当てた新しい文書と、変更のあったセクション番号
+func applyChanges(ctx context.Context, doc *extraction.DocTemplate, changes []change) (*extraction.DocTemplate, []int) {
+	images := map[string][]byte{}
+	for _, sec := range doc.Sections {
+		// 見出しの画像も、本文に移されたときに戻せるよう集める
+		if sec.Title != nil {
+			collectImages([]extraction.Block{*sec.Title}, images)
+		}
+		collectImages(sec.Body, images)
+	}
+
+	// 置き換え対象（セクション番号 → ブロック番号 → 置き換え後）
+	byBlock := map[int]map[int][]extraction.Block{}
+	titles := map[int]*extraction.Block{}
+	for _, c := range changes {
+		si, bi, ok := parseID(c.ID)
+		if !ok || si >= len(doc.Sections) {
+			slog.WarnContext(ctx, "修正対象の id が不正です", "id", c.ID)
+			continue
+		}
+		blocks := restoreImages(c.Blocks, images)
+		if bi < 0 {
+			if doc.Sections[si].Title == nil || len(blocks) == 0 {
+				continue
+			}
+			titles[si] = &blocks[0]
+			continue
+		}
+		if bi >= len(doc.Sections[si].Body) {
+			slog.WarnContext(ctx, "修正対象の id が不正です", "id", c.ID)
+			continue
+		}
+		if byBlock[si] == nil {
+			byBlock[si] = map[int][]extraction.Block{}
+		}
+		byBlock[si][bi] = blocks
+	}
+
+	revised := *doc
+	revised.Sections = make([]extraction.Section, len(doc.Sections))
+	var sections []int
+	for i, sec := range doc.Sections {
+		repl, title := byBlock[i], titles[i]
+		if repl == nil && title == nil {
+			revised.Sections[i] = sec
+			continue
+		}
+		sections = append(sections, i)
+		if title != nil {
+			sec.Title = title
+		}
+		body := make([]extraction.Block, 0, len(sec.Body))
+		for j, b := range sec.Body {
+			if blocks, ok := repl[j]; ok {
+				body = append(body, blocks...)
+				continue
+			}
+			body = append(body, b)
+		}
+		sec.Body = body
+		revised.Sections[i] = sec
+	}
+	sort.Ints(sections)
+	return &revised, sections
+}
+
+// "2-5" → (2, 5)、"2-title" → (2, -1)
+func parseID(id string) (section, block int, ok bool) {
+	s, b, found := strings.Cut(id, "-")
+	if !found {
+		return 0, 0, false
+	}
+	section, err := strconv.Atoi(s)
+	if err != nil || section < 0 {
+		return 0, 0, false
+	}
+	if b == "title" {
+		return section, -1, true
+	}
+	block, err = strconv.Atoi(b)
+	if err != nil || block < 0 {
+		return 0, 0, false
+	}
+	return section, block, true
+}
@@ -0,0 +1,193 @@
+package gemini
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go_project/extraction"
+)
+
+func TestParseID(t *testing.T) {
+	tests := []struct {
+		id             string
+		section, block int
+		ok             bool
+	}{
+		{"2-5", 2, 5, true},
+		{"0-0", 0, 0, true},
+		{"2-title", 2, -1, true},
+		{"2", 0, 0, false},
+		{"2-", 0, 0, false},
+		{"-1-2", 0, 0, false},
+		{"2--1", 0, 0, false},
+		{"a-1", 0, 0, false},
+		{"1-x", 0, 0, false},
+		{"1-Title", 0, 0, false},
+	}
+	for _, tt := range tests {
+		section, block, ok := parseID(tt.id)
+		if ok != tt.ok || (ok && (section != tt.section || block != tt.block)) {
+			t.Errorf("parseID(%q) = (%d, %d, %v), want (%d, %d, %v)", tt.id, section, block, ok, tt.section, tt.block, tt.ok)
+		}
+	}
+}
+
+func paragraph(text string) extraction.Block {
+	return extraction.Block{Kind: "paragraph", Runs: []extraction.Run{{Text: text}}}
+}
+
+func imageRef(name string) extraction.Block {
+	return extraction.Block{Kind: "image", Image: &extraction.ImageBlock{Name: name}}
+}
+
+// ブロックを比べやすい文字列にする（画像は name とデータ）
+func describe(b extraction.Block) string {
+	if b.Kind == "image" {
+		if b.Image == nil {
+			return "image:-"
+		}
+		return fmt.Sprintf("image:%s:%s", b.Image.Name, b.Image.Data)
+	}
+	var text strings.Builder
+	for _, r := range b.Runs {
+		text.WriteString(r.Text)
+	}
+	return text.String()
+}
+
+func describeSection(sec extraction.Section) []string {
+	out := []string{}
+	if sec.Title != nil {
+		out = append(out, "title:"+describe(*sec.Title))
+	}
+	for _, b := range sec.Body {
+		out = append(out, describe(b))
+	}
+	return out
+}
+
+func testDoc() *extraction.DocTemplate {
+	title := paragraph("はじめに")
+	banner := extraction.Block{Kind: "image", Image: &extraction.ImageBlock{Name: "banner.png", Data: []byte("B")}}
+	return &extraction.DocTemplate{Sections: []extraction.Section{
+		{Title: &title, Body: []extraction.Block{
+			paragraph("a"),
+			{Kind: "image", Image: &extraction.ImageBlock{Name: "logo.png", Data: []byte("L")}},
+			paragraph("c"),
+		}},
+		{Title: &banner, Body: []extraction.Block{paragraph("d")}},
+		{Body: []extraction.Block{paragraph("e")}},
+	}}
+}
+
+func TestApplyChanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		changes  []change
+		sections []int
+		want     [][]string // セクションごと（変更のないセクションも含む）
+	}{
+		{
+			name:     "削除",
+			changes:  []change{{ID: "0-0"}},
+			sections: []int{0},
+			want:     [][]string{{"title:はじめに", "image:logo.png:L", "c"}, {"title:image:banner.png:B", "d"}, {"e"}},
+		},
+		{
+			name:     "複数のブロックを挿入",
+			changes:  []change{{ID: "0-2", Blocks: []extraction.Block{paragraph("c1"), paragraph("c2")}}},
+			sections: []int{0},
+			want:     [][]string{{"title:はじめに", "a", "image:logo.png:L", "c1", "c2"}, {"title:image:banner.png:B", "d"}, {"e"}},
+		},
+		{
+			name:     "見出しの置き換え",
+			changes:  []change{{ID: "0-title", Blocks: []extraction.Block{paragraph("背景"), paragraph("余分")}}},
+			sections: []int{0},
+			want:     [][]string{{"title:背景", "a", "image:logo.png:L", "c"}, {"title:image:banner.png:B", "d"}, {"e"}},
+		},
+		{
+			name:     "見出しのないセクションの見出しは無視",
+			changes:  []change{{ID: "2-title", Blocks: []extraction.Block{paragraph("新しい見出し")}}},
+			sections: nil,
+			want:     [][]string{{"title:はじめに", "a", "image:logo.png:L", "c"}, {"title:image:banner.png:B", "d"}, {"e"}},
+		},
+		{
+			name:     "元の画像は name で戻す",
+			changes:  []change{{ID: "2-0", Blocks: []extraction.Block{imageRef("logo.png"), paragraph("e")}}},
+			sections: []int{2},
+			want:     [][]string{{"title:はじめに", "a", "image:logo.png:L", "c"}, {"title:image:banner.png:B", "d"}, {"image:logo.png:L", "e"}},
+		},
+		{
+			name:     "見出しの画像も戻せる",
+			changes:  []change{{ID: "1-0", Blocks: []extraction.Block{paragraph("d"), imageRef("banner.png")}}},
+			sections: []int{1},
+			want:     [][]string{{"title:はじめに", "a", "image:logo.png:L", "c"}, {"title:image:banner.png:B", "d", "image:banner.png:B"}, {"e"}},
+		},
+		{
+			name: "知らない・name のない画像は捨てる",
+			changes: []change{{ID: "0-1", Blocks: []extraction.Block{
+				imageRef("unknown.png"), {Kind: "image"}, {Kind: "paragraph", Image: &extraction.ImageBlock{Name: "x.png"}}, paragraph("b"),
+			}}},
+			sections: []int{0},
+			want:     [][]string{{"title:はじめに", "a", "b", "c"}, {"title:image:banner.png:B", "d"}, {"e"}},
+		},
+		{
+			name:     "不正・範囲外の id は無視",
+			changes:  []change{{ID: "x"}, {ID: "3-0"}, {ID: "0-3"}, {ID: "1-title-2"}},
+			sections: nil,
+			want:     [][]string{{"title:はじめに", "a", "image:logo.png:L", "c"}, {"title:image:banner.png:B", "d"}, {"e"}},
+		},
+		{
+			name:     "複数のセクション",
+			changes:  []change{{ID: "2-0", Blocks: []extraction.Block{paragraph("E")}}, {ID: "0-0", Blocks: []extraction.Block{paragraph("A")}}},
+			sections: []int{0, 2},
+			want:     [][]string{{"title:はじめに", "A", "image:logo.png:L", "c"}, {"title:image:banner.png:B", "d"}, {"E"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := testDoc()
+			before := make([][]string, len(doc.Sections))
+			for i, sec := range doc.Sections {
+				before[i] = describeSection(sec)
+			}
+
+			revised, sections := applyChanges(context.Background(), doc, tt.changes)
+			if !reflect.DeepEqual(sections, tt.sections) {
+				t.Errorf("変更したセクション = %v, want %v", sections, tt.sections)
+			}
+			for i, sec := range revised.Sections {
+				if got := describeSection(sec); !reflect.DeepEqual(got, tt.want[i]) {
+					t.Errorf("セクション %d = %q, want %q", i, got, tt.want[i])
+				}
+			}
+			for i, sec := range doc.Sections {
+				if got := describeSection(sec); !reflect.DeepEqual(got, before[i]) {
+					t.Errorf("元の文書のセクション %d が変わりました: %q", i, got)
+				}
+			}
+		})
+	}
+}
+
+// Gemini に渡す文書には id が付き、画像の中身は含めない
+func TestWithIDs(t *testing.T) {
+	sections := withIDs(testDoc())
+	if len(sections) != 3 {
+		t.Fatalf("セクション %d 個, want 3", len(sections))
+	}
+	title := sections[1]["title"].(idBlock)
+	if title.ID != "1-title" || title.Block.Image == nil || title.Block.Image.Name != "banner.png" || title.Block.Image.Data != nil {
+		t.Errorf("見出し = %+v", title)
+	}
+	body := sections[0]["body"].([]idBlock)
+	if len(body) != 3 || body[1].ID != "0-1" || body[1].Block.Image.Data != nil {
+		t.Errorf("本文 = %+v", body)
+	}
+	if _, ok := sections[2]["title"]; ok {
+		t.Error("見出しのないセクションに title があります")
+	}
+}
@@ -0,0 +1,876 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	azure "go_project/azurefolder"
+	"go_project/extraction"
+	"go_project/gemini"
+	"go_project/logging"
+	"go_project/metrics"
+	"go_project/quota"
+	"go_project/research"
+	"go_project/router"
+	"go_project/supabase"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+/* =======================
+   コマンド・モードごとの処理
+======================= */
+
+const (
+	modeChat     = "chat"
+	modeGenerate = "generate"
+	modeRevise   = "revise" // 次のテキストを修正指示として扱う
+)
+
+// ルーティングの定義（新しいコマンドはここに登録する）
+func newRouter(modes router.ModeStore, templates *templateStore) *router.Router {
+	rt := router.New(modes)
+	rt.Use(logMiddleware, rateLimitMiddleware, authMiddleware)
+
+	rt.Follow(router.HandlerFunc(follow))
+
+	rt.Command("#会話", modeCommand{
+		mode:     modeChat,
+		enabled:  func() bool { return cfg.Features.Chat },
+		disabled: "会話モードは現在ご利用いただけません",
+		message:  "会話モードに切り替えました",
+	}, "#chat")
+	rt.Command("#生成", modeCommand{
+		mode:     modeGenerate,
+		enabled:  func() bool { return cfg.Features.Generate },
+		disabled: "生成モードは現在ご利用いただけません",
+		message:  "生成モードです。\nWordテンプレート（.docx）を送信してください",
+		enter:    templates.Clear,
+	}, "#generate")
+	rt.Command("#残り", router.HandlerFunc(remaining), "#usage")
+	rt.Command("#認証", router.HandlerFunc(authenticated))
+	rt.Command("#再生成", regenerateHandler{templates}, "#regenerate")
+	rt.Command("#修正", reviseHandler{templates}, "#revise")
+	rt.Command("#完了", finishHandler{templates}, "#done")
+	rt.Command("#取消", cancelHandler{templates}, "#cancel")
+	rt.Command("#図", captionHandler{templates}, "#figure")
+
+	fileOnlyInGenerate := router.HandlerFunc(func(ctx context.Context, req *router.Request) {
+		req.ReplyText(ctx, "ファイル・画像の送信は生成モードで行ってください")
+	})
+	rt.NoMode(router.HandlerFunc(func(ctx context.Context, req *router.Request) {
+		req.Send(ctx, modeReply("#会話 または #生成 を選択してください"))
+	}), fileOnlyInGenerate)
+	rt.Mode(modeChat, router.HandlerFunc(chat), fileOnlyInGenerate)
+	rt.Mode(modeGenerate, generateHandler{templates}, generateFileHandler{templates})
+	rt.Mode(modeRevise, reviseHandler{templates}, router.HandlerFunc(func(ctx context.Context, req *router.Request) {
+		req.ReplyText(ctx, "修正内容をテキストで送信してください")
+	}))
+
+	return rt
+}
+
+func follow(ctx context.Context, req *router.Request) {
+	req.Send(ctx, modeReply("認証済みです。\n#会話\n#生成\nから選択してください\n（#残り で今月の利用状況を確認できます）"))
+}
+
+// リッチメニューの「認証について」（未認証なら認証ミドルウェアが案内する）
+func authenticated(ctx context.Context, req *router.Request) {
+	text := "認証済みです"
+	if req.User.ExpiresAt != nil {
+		text += "（" + req.User.ExpiresAt.In(jst).Format("2006/01/02") + "まで利用できます）"
+	}
+	req.Send(ctx, modeReply(text))
+}
+
+/* ---------- クイックリプライ ---------- */
+
+// モード選択のボタン（無効な機能は出さない）
+func modeQuickReply() *linebot.QuickReplyItems {
+	var buttons []*linebot.QuickReplyButton
+	add := func(label, command string) {
+		buttons = append(buttons, linebot.NewQuickReplyButton("",
+			linebot.NewPostbackAction(label, router.PostbackData(command, ""), "", command, "", "")))
+	}
+	if cfg.Features.Chat {
+		add("会話", "#会話")
+	}
+	if cfg.Features.Generate {
+		add("生成", "#生成")
+	}
+	add("利用状況", "#残り")
+	return linebot.NewQuickReplyItems(buttons...)
+}
+
+// モード選択のボタン付きテキスト
+func modeReply(text string) linebot.SendingMessage {
+	return linebot.NewTextMessage(text).WithQuickReplies(modeQuickReply())
+}
+
+/* ---------- モード切替 ---------- */
+
+type modeCommand struct {
+	mode     string
+	enabled  func() bool
+	disabled string // 機能が無効なときの案内
+	message  string
+	enter    func(userID string) // 切替時の初期化
+}
+
+func (c modeCommand) Handle(ctx context.Context, req *router.Request) {
+	if !c.enabled() {
+		req.Send(ctx, modeReply(c.disabled))
+		return
+	}
+	req.SetMode(c.mode)
+	if c.enter != nil {
+		c.enter(req.UserID)
+	}
+	req.ReplyText(ctx, c.message)
+}
+
+/* ---------- #残り ---------- */
+
+func remaining(ctx context.Context, req *router.Request) {
+	summary, err := quota.Summary(ctx, req.UserID)
+	if err != nil {
+		slog.ErrorContext(ctx, "利用状況の取得失敗", logging.Err(err))
+		req.ReplyText(ctx, "通信エラーが発生しました")
+		return
+	}
+	req.ReplyText(ctx, summary)
+}
+
+/* ---------- 会話モード ---------- */
+
+func chat(ctx context.Context, req *router.Request) {
+	if !recheckUser(ctx, req) {
+		return
+	}
+	ok, err := quota.Consume(ctx, req.UserID, quota.Chat)
+	if err != nil {
+		slog.ErrorContext(ctx, "利用枠の確認失敗", "kind", "chat", logging.Err(err))
+		req.ReplyText(ctx, "通信エラーが発生しました")
+		return
+	}
+	if !ok {
+		req.ReplyText(ctx, "今月の会話回数の上限に達しました。\n#残り で利用状況を確認できます")
+		return
+	}
+
+	out, err := gemini.ChatAiSystem(ctx, req.Text)
+	if err != nil {
+		quota.Release(context.WithoutCancel(ctx), req.UserID, quota.Chat)
+		req.ReplyText(ctx, "AI応答に失敗しました")
+		return
+	}
+	req.ReplyText(ctx, out)
+}
+
+/* ---------- 生成モード ---------- */
+
+// ユーザーごとの解析済みテンプレート
+type templateStore struct {
+	mu     sync.Mutex
+	json   map[string]string                  // ★ Word構造JSON
+	paths  map[string]string                  // 保存用（任意）
+	pages  map[string]extraction.PageSettings // 用紙設定（JSON に含まれない）
+	jobs   map[string][]*jobInput             // 最近のジョブの入力（#再生成 用、新しいものが後ろ）
+	drafts map[string][]draftPart             // #完了 まで貯める研究内容
+	last   map[string]*lastDocument
+	used   map[string]time.Time // 最後に操作した時刻（古いものから捨てる）
+	pruned time.Time
+}
+
+// 研究内容の下書きの 1 件（テキストメッセージ・ファイル・図）
+type draftPart struct {
+	Name   string // ファイル名（テキストメッセージなら空）
+	Text   string
+	Figure *extraction.Figure
+}
+
+// 生成に渡す研究内容
+type researchInput struct {
+	Text    string
+	Figures []extraction.Figure
+}
+
+var (
+	// 1 回の生成で使える図の数
+	MaxFigures = 10
+	// 図 1 枚の大きさの上限
+	MaxFigureBytes int64 = 10 << 20
+
+	// 結果カードから再生成できるジョブの数（新しいものから）
+	MaxJobHistory = 5
+
+	// 操作がないまま下書きを残しておく時間
+	DraftTTL = time.Hour
+	// 操作がないままテンプレート・最後の版などを残しておく時間
+	StoreTTL = 24 * time.Hour
+)
+
+// 最後に作った版（#修正 の元）
+type lastDocument struct {
+	JobID   string
+	Version int
+	Doc     *extraction.DocTemplate
+}
+
+// 1 回の生成・修正の入力（結果カードの「再生成」で同じ入力からやり直す）
+type jobInput struct {
+	JobID       string
+	Template    string                  // Word構造JSON
+	Page        extraction.PageSettings // テンプレートの用紙設定
+	Research    researchInput           // 生成の研究内容
+	Parent      *lastDocument           // 修正の元の版（生成なら nil）
+	Instruction string                  // 修正の指示
+}
+
+func (in *jobInput) label() string {
+	if in.Parent != nil {
+		return "修正"
+	}
+	return "生成"
+}
+
+// 書き出した .docx と文書、修正したセクション番号を返す
+func (in *jobInput) run(ctx context.Context) (string, *extraction.DocTemplate, []int, error) {
+	if in.Parent != nil {
+		return gemini.ReviseAiSystem(ctx, in.Parent.Doc, in.Instruction)
+	}
+	out, doc, err := gemini.GenerateAiSystem(ctx, in.Template, in.Page, in.Research.Text, in.Research.Figures)
+	return out, doc, nil, err
+}
+
+func newTemplateStore() *templateStore {
+	return &templateStore{
+		json:   map[string]string{},
+		paths:  map[string]string{},
+		pages:  map[string]extraction.PageSettings{},
+		jobs:   map[string][]*jobInput{},
+		drafts: map[string][]draftPart{},
+		last:   map[string]*lastDocument{},
+		used:   map[string]time.Time{},
+	}
+}
+
+// userID の利用時刻を更新し、ときどき古いものを捨てる（mu を保持して呼ぶ）
+func (s *templateStore) touch(userID string) {
+	now := time.Now()
+	s.used[userID] = now
+	if now.Sub(s.pruned) < time.Minute {
+		return
+	}
+	s.pruned = now
+	for id, t := range s.used {
+		switch idle := now.Sub(t); {
+		case idle >= StoreTTL:
+			s.forget(id)
+		case idle >= DraftTTL:
+			// 図のバイト列を抱えたままにしない
+			delete(s.drafts, id)
+		}
+	}
+}
+
+// mu を保持して呼ぶ
+func (s *templateStore) forget(userID string) {
+	delete(s.json, userID)
+	delete(s.paths, userID)
+	delete(s.pages, userID)
+	delete(s.jobs, userID)
+	delete(s.drafts, userID)
+	delete(s.last, userID)
+	delete(s.used, userID)
+}
+
+func (s *templateStore) Get(userID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touch(userID)
+	return s.json[userID]
+}
+
+func (s *templateStore) Page(userID string) extraction.PageSettings {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touch(userID)
+	return s.pages[userID]
+}
+
+// テンプレートを差し替える（前のテンプレートの下書き・版は捨てる。再生成用の履歴は入力ごと残す）
+func (s *templateStore) Set(userID, path, templateJSON string, page extraction.PageSettings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touch(userID)
+	s.json[userID] = templateJSON
+	s.paths[userID] = path
+	s.pages[userID] = page
+	delete(s.drafts, userID)
+	delete(s.last, userID)
+}
+
+// jobID のジョブの入力（空なら最後のジョブ）。履歴から外れていれば nil。
+func (s *templateStore) Job(userID, jobID string) *jobInput {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touch(userID)
+	jobs := s.jobs[userID]
+	for i := len(jobs) - 1; i >= 0; i-- {
+		if jobID == "" || jobs[i].JobID == jobID {
+			return jobs[i]
+		}
+	}
+	return nil
+}
+
+// 終わったジョブの入力を残す（MaxJobHistory を超えたら古いものから捨てる）
+func (s *templateStore) AddJob(userID string, in *jobInput) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touch(userID)
+	jobs := append(s.jobs[userID], in)
+	if n := len(jobs) - MaxJobHistory; n > 0 {
+		jobs = append([]*jobInput(nil), jobs[n:]...)
+	}
+	s.jobs[userID] = jobs
+}
+
+func (s *templateStore) Clear(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.forget(userID)
+}
+
+// 上限を超えるなら追加せずに false。戻り値は追加後の合計文字数。
+func (s *templateStore) AddDraft(userID string, p draftPart) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touch(userID)
+	n := utf8.RuneCountInString(p.Text)
+	for _, q := range s.drafts[userID] {
+		n += utf8.RuneCountInString(q.Text)
+	}
+	if n > research.MaxChars {
+		return n, false
+	}
+	s.drafts[userID] = append(s.drafts[userID], p)
+	return n, true
+}
+
+// 図を下書きに足す。上限に達していれば false。戻り値は図番号。
+func (s *templateStore) AddFigure(userID string, data []byte, format string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touch(userID)
+	n := 0
+	for _, p := range s.drafts[userID] {
+		if p.Figure != nil {
+			n++
+		}
+	}
+	if n >= MaxFigures {
+		return n, false
+	}
+	n++
+	f := &extraction.Figure{Name: fmt.Sprintf("figure%d.%s", n, format), Data: data}
+	s.drafts[userID] = append(s.drafts[userID], draftPart{Figure: f})
+	return n, true
+}
+
+// 最後に送った図に説明を付ける。図がなければ 0。
+func (s *templateStore) CaptionFigure(userID, caption string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touch(userID)
+	n, last := 0, (*extraction.Figure)(nil)
+	for _, p := range s.drafts[userID] {
+		if p.Figure != nil {
+			n++
+			last = p.Figure
+		}
+	}
+	if last != nil {
+		last.Caption = caption
+	}
+	return n
+}
+
+// 取り出した下書きを先頭に戻す
+func (s *templateStore) RestoreDraft(userID string, parts []draftPart) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touch(userID)
+	s.drafts[userID] = append(parts, s.drafts[userID]...)
+}
+
+// 下書きを取り出して空にする
+func (s *templateStore) TakeDraft(userID string) []draftPart {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touch(userID)
+	parts := s.drafts[userID]
+	delete(s.drafts, userID)
+	return parts
+}
+
+func (s *templateStore) Last(userID string) *lastDocument {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touch(userID)
+	return s.last[userID]
+}
+
+func (s *templateStore) SetLast(userID string, d *lastDocument) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touch(userID)
+	s.last[userID] = d
+}
+
+// 研究内容のテキストを下書きに貯める（生成は #完了 で始める）
+type generateHandler struct {
+	templates *templateStore
+}
+
+func (h generateHandler) Handle(ctx context.Context, req *router.Request) {
+	if h.templates.Get(req.UserID) == "" {
+		req.ReplyText(ctx, "先に Wordテンプレート（.docx）を送信してください")
+		return
+	}
+	total, ok := h.templates.AddDraft(req.UserID, draftPart{Text: req.Text})
+	if !ok {
+		req.Send(ctx, draftReply(fmt.Sprintf("研究内容が長すぎます（合計%d文字まで）。\nこのメッセージは追加していません", research.MaxChars)))
+		return
+	}
+	req.Send(ctx, draftReply(fmt.Sprintf("📝 下書きに追加しました（%d文字、合計%d文字）\n"+
+		"続きを送るか、#完了 で生成を開始します（#取消 で破棄）", utf8.RuneCountInString(req.Text), total)))
+}
+
+// 下書きの操作ボタン付きテキスト
+func draftReply(text string) linebot.SendingMessage {
+	return linebot.NewTextMessage(text).WithQuickReplies(linebot.NewQuickReplyItems(
+		linebot.NewQuickReplyButton("", linebot.NewPostbackAction("完了", router.PostbackData("#完了", ""), "", "#完了", "", "")),
+		linebot.NewQuickReplyButton("", linebot.NewPostbackAction("取消", router.PostbackData("#取消", ""), "", "#取消", "", "")),
+	))
+}
+
+// 下書きを送った順につなげる（ファイルは見出しを付ける。図は別に並べる）
+func composeResearch(parts []draftPart) researchInput {
+	var in researchInput
+	var sb strings.Builder
+	for _, p := range parts {
+		if p.Figure != nil {
+			in.Figures = append(in.Figures, *p.Figure)
+			continue
+		}
+		if p.Name != "" {
+			sb.WriteString("【ファイル：" + p.Name + "】\n")
+		}
+		sb.WriteString(p.Text + "\n\n")
+	}
+	in.Text = strings.TrimSpace(sb.String())
+	return in
+}
+
+// #完了：下書きから生成を始める
+type finishHandler struct {
+	templates *templateStore
+}
+
+func (h finishHandler) Handle(ctx context.Context, req *router.Request) {
+	if req.Mode != modeGenerate {
+		req.ReplyText(ctx, "#完了 は生成モードで研究内容を送ってから使ってください")
+		return
+	}
+	if h.templates.Get(req.UserID) == "" {
+		req.ReplyText(ctx, "先に Wordテンプレート（.docx）を送信してください")
+		return
+	}
+	parts := h.templates.TakeDraft(req.UserID)
+	if args := strings.TrimSpace(req.Args); args != "" {
+		// 「#完了 補足」の補足も研究内容に含める
+		parts = append(parts, draftPart{Text: args})
+	}
+	in := composeResearch(parts)
+	if in.Text == "" {
+		// 図だけでは生成しないので下書きは戻す
+		h.templates.RestoreDraft(req.UserID, parts)
+		req.ReplyText(ctx, "研究内容がまだありません。\nテキストかファイルで送信してから #完了 を送ってください")
+		return
+	}
+	generateHandler{h.templates}.generate(ctx, req, in)
+}
+
+// #取消：下書き・修正待ちを破棄する
+type cancelHandler struct {
+	templates *templateStore
+}
+
+func (h cancelHandler) Handle(ctx context.Context, req *router.Request) {
+	discarded := len(h.templates.TakeDraft(req.UserID)) > 0
+	if req.Mode == modeRevise {
+		req.SetMode(modeGenerate)
+		req.ReplyText(ctx, "修正を取り消しました")
+		return
+	}
+	if !discarded {
+		req.ReplyText(ctx, "取り消す下書きはありません")
+		return
+	}
+	req.ReplyText(ctx, "下書きを破棄しました。\n研究内容を最初から送信してください")
+}
+
+func (h generateHandler) generate(ctx context.Context, req *router.Request, in researchInput) {
+	tmpl := h.templates.Get(req.UserID)
+	if tmpl == "" {
+		req.ReplyText(ctx, "先に Wordテンプレート（.docx）を送信してください")
+		return
+	}
+	h.templates.produce(ctx, req, &jobInput{Template: tmpl, Page: h.templates.Page(req.UserID), Research: in})
+}
+
+// 利用枠の確保・ジョブの記録・アップロード・結果カードの送信（生成と修正で共通）。
+// できた版は #修正 の元として、入力は #再生成 用に残す。
+func (s *templateStore) produce(ctx context.Context, req *router.Request, job *jobInput) {
+	userID := req.UserID
+	label := job.label()
+
+	if !recheckUser(ctx, req) {
+		return
+	}
+	ok, err := quota.Consume(ctx, userID, quota.Generation)
+	if err != nil {
+		slog.ErrorContext(ctx, "利用枠の確認失敗", "kind", "generation", logging.Err(err))
+		req.ReplyText(ctx, "通信エラーが発生しました")
+		return
+	}
+	if !ok {
+		req.ReplyText(ctx, "今月の生成回数の上限に達しました。\n#残り で利用状況を確認できます")
+		return
+	}
+
+	jobID := newJobID()
+	templateHash := azure.HashTemplate(job.Template)
+	if err := supabase.CreateJob(ctx, jobID, userID, templateHash, gemini.Model); err != nil {
+		slog.ErrorContext(ctx, "job 記録失敗", "job_id", jobID, logging.Err(err))
+	}
+
+	meta := azure.DocMeta{
+		LineUserID:   userID,
+		JobID:        jobID,
+		TemplateHash: templateHash,
+		Model:        gemini.Model,
+		Version:      1,
+	}
+	if job.Parent != nil {
+		meta.ParentJobID = job.Parent.JobID
+		meta.Version = job.Parent.Version + 1
+	}
+
+	done := metrics.GenerationStarted()
+	start := time.Now()
+	out, doc, sections, err := job.run(ctx)
+	if err != nil {
+		done()
+		slog.ErrorContext(ctx, label+"失敗", "job_id", jobID, logging.Err(err))
+		failJob(ctx, jobID, err)
+		quota.Release(context.WithoutCancel(ctx), userID, quota.Generation)
+		if errors.Is(err, gemini.ErrNoRevision) {
+			req.ReplyText(ctx, "指示に当てはまる箇所が見つかりませんでした。\n直したい箇所を具体的に送信してください")
+			return
+		}
+		req.ReplyText(ctx, label+"に失敗しました")
+		return
+	}
+	defer os.Remove(out)
+
+	container := azure.Container()
+	blobName := jobID + ".docx"
+
+	meta.CreatedAt = start
+	err = azure.UploadDocx(ctx, container, blobName, out, meta)
+	done()
+	if err != nil {
+		failJob(ctx, jobID, err)
+		quota.Release(context.WithoutCancel(ctx), userID, quota.Generation)
+		req.ReplyText(ctx, "faileのアップロードに失敗しました")
+		return
+	}
+
+	if err := supabase.FinishJob(ctx, jobID, container, blobName); err != nil {
+		slog.ErrorContext(ctx, "job 記録失敗", "job_id", jobID, logging.Err(err))
+	}
+	s.SetLast(userID, &lastDocument{JobID: jobID, Version: meta.Version, Doc: doc})
+	finished := *job // 再生成なら job は履歴の 1 件なので書き換えない
+	finished.JobID = jobID
+	s.AddJob(userID, &finished)
+
+	sasURL, err := azure.GenerateBlobSASURL(ctx, container, blobName, linkMinutes)
+	if err != nil {
+		req.ReplyText(ctx, "ダウンロードリンクの作成に失敗しました")
+		return
+	}
+	now := time.Now()
+	res := result{
+		JobID:     jobID,
+		Version:   meta.Version,
+		URL:       sasURL,
+		Expires:   now.Add(linkMinutes * time.Minute),
+		CreatedAt: start,
+		Duration:  now.Sub(start),
+		Summary:   extraction.Summarize(doc),
+	}
+	for _, i := range sections {
+		res.Revised = append(res.Revised, doc.Sections[i].Heading())
+	}
+	sendResult(ctx, req, res)
+}
+
+// 結果カードの「再生成」：そのカードのジョブと同じ入力でもう一度作る
+// （生成なら同じテンプレートと研究内容、修正なら同じ版と指示）。
+// 「#再生成」だけなら最後のジョブをやり直す。
+type regenerateHandler struct {
+	templates *templateStore
+}
+
+func (h regenerateHandler) Handle(ctx context.Context, req *router.Request) {
+	if !cfg.Features.Generate {
+		req.ReplyText(ctx, "生成モードは現在ご利用いただけません")
+		return
+	}
+	job := h.templates.Job(req.UserID, req.Args)
+	switch {
+	case job == nil && req.Args != "":
+		req.ReplyText(ctx, "この結果は古いため再生成できません。\n#生成 からやり直してください")
+		return
+	case job == nil:
+		req.ReplyText(ctx, "再生成できる文書がありません。\n#生成 からやり直してください")
+		return
+	}
+	h.templates.produce(ctx, req, job)
+}
+
+/* ---------- 修正 ---------- */
+
+// 最後に作った版を指示に沿って部分的に書き直す。
+// 「#修正 考察をもっと詳しく」はそのまま、「#修正」だけなら次のテキストを指示として受け取る。
+type reviseHandler struct {
+	templates *templateStore
+}
+
+func (h reviseHandler) Handle(ctx context.Context, req *router.Request) {
+	if !cfg.Features.Generate {
+		req.ReplyText(ctx, "生成モードは現在ご利用いただけません")
+		return
+	}
+	last := h.templates.Last(req.UserID)
+	if last == nil {
+		req.ReplyText(ctx, "修正できる文書がありません。\n先に文書を生成してください")
+		return
+	}
+
+	instruction := req.Args
+	if req.Command == "" {
+		// 修正待ちで受け取ったテキスト
+		instruction = req.Text
+		req.SetMode(modeGenerate)
+	}
+	if instruction == "" {
+		req.SetMode(modeRevise)
+		req.ReplyText(ctx, "修正したい内容を送信してください\n（例：考察をもっと詳しく／英文表題を修正）")
+		return
+	}
+
+	h.templates.produce(ctx, req, &jobInput{
+		Template:    h.templates.Get(req.UserID),
+		Page:        h.templates.Page(req.UserID),
+		Parent:      last,
+		Instruction: instruction,
+	})
+}
+
+/* ---------- ファイル ---------- */
+
+// 生成モードのファイル：テンプレートがまだならテンプレート、あれば研究内容として扱う
+type generateFileHandler struct {
+	templates *templateStore
+}
+
+func (h generateFileHandler) Handle(ctx context.Context, req *router.Request) {
+	if req.Kind == router.KindImage {
+		figureHandler{h.templates}.Handle(ctx, req)
+		return
+	}
+	if h.templates.Get(req.UserID) == "" {
+		templateFileHandler{h.templates}.Handle(ctx, req)
+		return
+	}
+	researchFileHandler{h.templates}.Handle(ctx, req)
+}
+
+// 研究内容のファイル（.docx・.txt・.md・PDF）からテキストを取り出して下書きに貯める
+type researchFileHandler struct {
+	templates *templateStore
+}
+
+func (h researchFileHandler) Handle(ctx context.Context, req *router.Request) {
+	if !research.Supported(req.FileName) {
+		req.ReplyText(ctx, "研究内容として使えるのは "+strings.Join(research.Extensions, " / ")+" です。\n"+
+			"テンプレートを差し替える場合は #生成 からやり直してください")
+		return
+	}
+
+	content, err := req.Replier.Content(ctx, req.MessageID)
+	if err != nil {
+		req.ReplyText(ctx, "ファイル取得に失敗しました")
+		return
+	}
+	defer content.Close()
+
+	text, err := research.Extract(ctx, req.FileName, content)
+	switch {
+	case errors.Is(err, research.ErrTooLarge):
+		req.ReplyText(ctx, fmt.Sprintf("ファイルが大きすぎます（%dMBまで）", research.MaxFileBytes>>20))
+		return
+	case errors.Is(err, research.ErrNoText):
+		req.ReplyText(ctx, "ファイルから文字を読み取れませんでした。\n（画像だけの PDF には対応していません）")
+		return
+	case err != nil:
+		slog.WarnContext(ctx, "研究内容ファイルの読み込み失敗", logging.Err(err))
+		req.ReplyText(ctx, "ファイルの読み込みに失敗しました")
+		return
+	}
+
+	total, ok := h.templates.AddDraft(req.UserID, draftPart{Name: req.FileName, Text: text})
+	if !ok {
+		req.Send(ctx, draftReply(fmt.Sprintf("研究内容が長すぎます（合計%d文字まで）。\nこのファイルは追加していません", research.MaxChars)))
+		return
+	}
+	req.Send(ctx, draftReply(fmt.Sprintf("📄 下書きに追加しました（%d文字、合計%d文字）\n"+
+		"続きを送るか、#完了 で生成を開始します（#取消 で破棄）", utf8.RuneCountInString(text), total)))
+}
+
+// 写真を図として下書きに貯める（生成時に文書の画像ブロックへ順に差し込む）
+type figureHandler struct {
+	templates *templateStore
+}
+
+func (h figureHandler) Handle(ctx context.Context, req *router.Request) {
+	if h.templates.Get(req.UserID) == "" {
+		req.ReplyText(ctx, "先に Wordテンプレート（.docx）を送信してください")
+		return
+	}
+
+	content, err := req.Replier.Content(ctx, req.MessageID)
+	if err != nil {
+		req.ReplyText(ctx, "画像の取得に失敗しました")
+		return
+	}
+	defer content.Close()
+
+	data, err := io.ReadAll(io.LimitReader(content, MaxFigureBytes+1))
+	if err != nil {
+		req.ReplyText(ctx, "画像の取得に失敗しました")
+		return
+	}
+	if int64(len(data)) > MaxFigureBytes {
+		req.ReplyText(ctx, fmt.Sprintf("画像が大きすぎます（%dMBまで）", MaxFigureBytes>>20))
+		return
+	}
+	// Word に埋め込める形式か（LINE の写真は JPEG）
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || (format != "jpeg" && format != "png") {
+		req.ReplyText(ctx, "対応している画像は JPEG / PNG のみです")
+		return
+	}
+
+	n, ok := h.templates.AddFigure(req.UserID, data, format)
+	if !ok {
+		req.Send(ctx, draftReply(fmt.Sprintf("図は %d 枚までです。この画像は追加していません", MaxFigures)))
+		return
+	}
+	slog.InfoContext(ctx, "図を受け取りました", "figure", n, "format", format, "bytes", len(data))
+	req.Send(ctx, draftReply(fmt.Sprintf("🖼 図%dとして下書きに追加しました。\n"+
+		"説明を付けるには「#図 説明」を送信してください", n)))
+}
+
+// #図：最後に送った図に説明（キャプション）を付ける
+type captionHandler struct {
+	templates *templateStore
+}
+
+func (h captionHandler) Handle(ctx context.Context, req *router.Request) {
+	caption := strings.TrimSpace(req.Args)
+	if caption == "" {
+		req.ReplyText(ctx, "「#図 説明」の形で送信してください")
+		return
+	}
+	n := h.templates.CaptionFigure(req.UserID, caption)
+	if n == 0 {
+		req.ReplyText(ctx, "説明を付ける図がありません。\n先に生成モードで画像を送信してください")
+		return
+	}
+	req.Send(ctx, draftReply(fmt.Sprintf("図%dの説明を「%s」にしました", n, caption)))
+}
+
+// Wordテンプレート（.docx）を受け取って構造を解析する
+type templateFileHandler struct {
+	templates *templateStore
+}
+
+func (h templateFileHandler) Handle(ctx context.Context, req *router.Request) {
+	if !strings.HasSuffix(strings.ToLower(req.FileName), ".docx") {
+		req.ReplyText(ctx, "対応しているのは Word（.docx）のみです")
+		return
+	}
+
+	content, err := req.Replier.Content(ctx, req.MessageID)
+	if err != nil {
+		req.ReplyText(ctx, "ファイル取得に失敗しました")
+		return
+	}
+	defer content.Close()
+
+	path := "/tmp/" + req.UserID + "_template.docx"
+
+	f, err := os.Create(path)
+	if err != nil {
+		req.ReplyText(ctx, "ファイル保存に失敗しました")
+		return
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, content); err != nil {
+		req.ReplyText(ctx, "ファイル書き込みに失敗しました")
+		return
+	}
+
+	// ★ Word構造抽出
+	docStruct, err := extraction.ExtractWordStructure(ctx, path)
+	if err != nil {
+		req.ReplyText(ctx, "Word構造の解析に失敗しました")
+		return
+	}
+
+	jsonBytes, _ := json.MarshalIndent(docStruct, "", "  ")
+	h.templates.Set(req.UserID, path, string(jsonBytes), docStruct.Page())
+
+	req.ReplyText(ctx,
+		"✅ Wordテンプレートを解析しました\n"+
+			"次に【研究内容】をテキストかファイル（"+strings.Join(research.Extensions, " / ")+"）で送信し、\n"+
+			"送り終えたら #完了 を送ってください",
+	)
+}
@@ -0,0 +1,157 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go_project/logging"
+)
+
+/* =======================
+   依存サービスの準備状況（/ready）
+======================= */
+
+var (
+	// 結果を使い回す時間（/ready を頻繁に叩かれても外部APIに負荷をかけない）
+	CacheTTL = 30 * time.Second
+	// 失敗した結果はすぐ再確認する
+	FailureTTL = 5 * time.Second
+	// 1件の確認にかける上限
+	Timeout = 5 * time.Second
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+
+	StatusDraining = "draining"
+)
+
+// 1件の確認結果
+type Result struct {
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	LatencyMS int64     `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+type check struct {
+	name string
+	fn   func(ctx context.Context) error
+
+	mu   sync.Mutex // 同じ確認を同時に走らせない
+	last *Result
+}
+
+var (
+	mu     sync.Mutex
+	checks []*check
+)
+
+// 確認項目を登録する（起動時に有効な機能の分だけ）
+func Register(name string, fn func(ctx context.Context) error) {
+	mu.Lock()
+	defer mu.Unlock()
+	checks = append(checks, &check{name: name, fn: fn})
+}
+
+func (c *check) run(ctx context.Context) Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.last != nil {
+		ttl := CacheTTL
+		if c.last.Status != StatusOK {
+			ttl = FailureTTL
+		}
+		if now.Sub(c.last.CheckedAt) < ttl {
+			return *c.last
+		}
+	}
+
+	// 呼び出し元が切断しても結果はキャッシュするので最後まで確認する
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), Timeout)
+	defer cancel()
+
+	err := c.fn(ctx)
+	res := Result{
+		Status:    StatusOK,
+		LatencyMS: time.Since(now).Milliseconds(),
+		CheckedAt: now,
+	}
+	if err != nil {
+		res.Status = StatusFail
+		res.Error = err.Error()
+		slog.WarnContext(ctx, "依存サービスの確認に失敗", "check", c.name, logging.Err(err))
+	}
+	c.last = &res
+	return res
+}
+
+// Report は全項目の結果
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks,omitempty"`
+}
+
+// 全項目を並行に確認する（キャッシュが新しければ使う）
+func Check(ctx context.Context) Report {
+	mu.Lock()
+	list := append([]*check(nil), checks...)
+	mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+
+	results := make([]Result, len(list))
+	var wg sync.WaitGroup
+	for i, c := range list {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.run(ctx)
+		}()
+	}
+	wg.Wait()
+
+	rep := Report{Status: StatusOK, Checks: map[string]Result{}}
+	for i, c := range list {
+		rep.Checks[c.name] = results[i]
+		if results[i].Status != StatusOK {
+			rep.Status = StatusFail
+		}
+	}
+	return rep
+}
+
+// 停止処理中（新しいリクエストを振り分けさせない）
+var draining atomic.Bool
+
+// SIGTERM を受けたら呼ぶ。以降 /ready は依存サービスを見ずに 503 を返す。
+func Drain() {
+	draining.Store(true)
+}
+
+// /ready のハンドラ（1件でも失敗なら 503）
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rep := Report{Status: StatusDraining}
+		if !draining.Load() {
+			rep = Check(r.Context())
+		}
+		status := http.StatusOK
+		if rep.Status != StatusOK {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(rep)
+	})
+}
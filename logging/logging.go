@@ -0,0 +1,159 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"go_project/config"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+/* =======================
+   構造化ログ（log/slog）
+======================= */
+
+// 伏せ字にするか（設定前も安全側）
+var redact atomic.Bool
+
+func init() {
+	redact.Store(true)
+}
+
+// 設定に応じた slog の既定ロガーを組み立てる。
+// 標準 log パッケージの出力も同じハンドラに流れる。
+func Configure(c config.Log) {
+	redact.Store(c.Redact)
+
+	opts := &slog.HandlerOptions{Level: parseLevel(c.Level)}
+	var h slog.Handler
+	if c.Format == "text" {
+		h = slog.NewTextHandler(os.Stderr, opts)
+	} else {
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(contextHandler{h}))
+}
+
+func parseLevel(s string) slog.Level {
+	switch s {
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+/* =======================
+   相関ID
+======================= */
+
+type ctxKey int
+
+const (
+	keyCorrelationID ctxKey = iota
+	keyUser
+)
+
+// 新しい相関ID（16桁の16進数）
+func NewID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// 1件のイベント（Webhook・HTTPリクエスト）に相関IDを付ける。空なら採番する。
+func NewContext(ctx context.Context, correlationID string) context.Context {
+	if correlationID == "" {
+		correlationID = NewID()
+	}
+	return context.WithValue(ctx, keyCorrelationID, correlationID)
+}
+
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(keyCorrelationID).(string)
+	return id
+}
+
+// 以降のログに対象ユーザーを付ける（出力時に伏せ字になる）
+func WithUser(ctx context.Context, lineUserID string) context.Context {
+	return context.WithValue(ctx, keyUser, lineUserID)
+}
+
+// context の相関ID・ユーザーを各レコードに付与するハンドラ
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx != nil {
+		if id := CorrelationID(ctx); id != "" {
+			r.AddAttrs(slog.String("correlation_id", id))
+		}
+		if user, ok := ctx.Value(keyUser).(string); ok && user != "" {
+			r.AddAttrs(User(user))
+		}
+		// トレースとログを突き合わせられるようにする
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+/* =======================
+   個人情報の伏せ字
+======================= */
+
+type userID string
+
+// LINEユーザーIDはハッシュの先頭だけ出す（同一ユーザーの追跡は可能）
+func (u userID) LogValue() slog.Value {
+	if !redact.Load() || u == "" {
+		return slog.StringValue(string(u))
+	}
+	sum := sha256.Sum256([]byte(u))
+	return slog.StringValue("u_" + hex.EncodeToString(sum[:6]))
+}
+
+type text string
+
+// 本文は文字数だけ出す
+func (t text) LogValue() slog.Value {
+	if !redact.Load() {
+		return slog.StringValue(string(t))
+	}
+	return slog.StringValue(fmt.Sprintf("[REDACTED %d文字]", utf8.RuneCountInString(string(t))))
+}
+
+// LINEユーザーID
+func User(lineUserID string) slog.Attr {
+	return slog.Any("user", userID(lineUserID))
+}
+
+// メッセージ本文・AI出力など
+func Text(key, s string) slog.Attr {
+	return slog.Any(key, text(s))
+}
+
+func Err(err error) slog.Attr {
+	return slog.Any("error", err)
+}
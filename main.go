@@ -1,77 +1,225 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"go_project/admin"
 	azure "go_project/azurefolder"
+	"go_project/config"
 	"go_project/extraction"
 	"go_project/gemini"
+	"go_project/health"
+	"go_project/logging"
+	"go_project/metrics"
+	"go_project/quota"
+	"go_project/richmenu"
+	"go_project/router"
 	"go_project/supabase"
+	"go_project/tracing"
 	"io"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"regexp"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/line/line-bot-sdk-go/v7/linebot"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var (
-	LINE_CHANNEL_SECRET       = os.Getenv("LINE_CHANNEL_SECRET")
-	LINE_CHANNEL_ACCESS_TOKEN = os.Getenv("LINE_CHANNEL_ACCESS_TOKEN")
+	cfg *config.Config
 
-	userMode      = map[string]string{} // chat / generate
-	templatePath = map[string]string{} // 保存用（任意）
-	templateJSON = map[string]string{} // ★ Word構造JSON
+	jst = time.FixedZone("JST", 9*60*60)
+
+	// 未送信のトレースを送り切る
+	shutdownTracing = func(context.Context) error { return nil }
+
+	// 処理中の Webhook イベント（停止時に待つ）
+	inflight sync.WaitGroup
 )
 
-func reply(bot *linebot.Client, ev *linebot.Event, text string) {
-	_, err := bot.ReplyMessage(
-		ev.ReplyToken,
-		linebot.NewTextMessage(text),
-	).Do()
+// LINE クライアントによる返信・コンテンツ取得
+type lineReplier struct {
+	bot        *linebot.Client
+	replyToken string
+}
+
+func (l lineReplier) Reply(ctx context.Context, messages ...linebot.SendingMessage) error {
+	_, err := l.bot.ReplyMessage(l.replyToken, messages...).WithContext(ctx).Do()
+	return err
+}
+
+func (l lineReplier) Content(ctx context.Context, messageID string) (io.ReadCloser, error) {
+	content, err := l.bot.GetMessageContent(messageID).WithContext(ctx).Do()
 	if err != nil {
-		log.Println("Reply error:", err)
+		return nil, err
+	}
+	return content.Content, nil
+}
+
+// 失敗したジョブを記録する（記録の失敗は生成結果に影響させない）
+func failJob(ctx context.Context, jobID string, cause error) {
+	// 停止で打ち切られた場合も記録は残す
+	ctx = context.WithoutCancel(ctx)
+	if err := supabase.FailJob(ctx, jobID, cause); err != nil {
+		slog.ErrorContext(ctx, "job 記録失敗", "job_id", jobID, logging.Err(err))
 	}
 }
 
+// LINE API のパスに入るユーザー・グループ・トークルームID（U/C/R + 32桁の16進）
+var lineIDPattern = regexp.MustCompile(`[UCR][0-9a-f]{32}`)
 
-func replyFile(bot *linebot.Client, ev *linebot.Event, text string) {
+func redactLineIDs(path string) string {
+	return lineIDPattern.ReplaceAllString(path, ":id")
+}
 
-	action := linebot.NewURIAction("リンクを見る", text)
-	buttonTemplate := linebot.NewButtonsTemplate(
-    	"", "ファイル", "頼まれていたファイルが完成しました",
-    	action,
-	)
-	_, err := bot.ReplyMessage(
-		ev.ReplyToken,
-		linebot.NewTemplateMessage(text,buttonTemplate),
-	).Do()
+// 生成ジョブID（BLOB名・タグに使う）
+func newJobID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// 起動を続けられないエラー
+func fatal(msg string, err error) {
+	slog.Error(msg, logging.Err(err))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	shutdownTracing(ctx)
+	cancel()
+	os.Exit(1)
+}
+
+// 設定を読み込み、検証してから各パッケージに渡す
+func setup() {
+	var err error
+	cfg, err = config.Load()
 	if err != nil {
-		log.Println("Reply error:", err)
+		fatal("設定の読み込みに失敗しました", err)
+	}
+	logging.Configure(cfg.Log)
+	if err := cfg.Validate(); err != nil {
+		fatal("設定が不正です", err)
+	}
+	slog.Info("設定を読み込みました", "config", cfg.Summary())
+
+	shutdownTracing, err = tracing.Configure(context.Background(), cfg.Tracing)
+	if err != nil {
+		fatal("トレースの初期化に失敗しました", err)
+	}
+
+	supabase.Configure(cfg.Supabase)
+	gemini.Configure(cfg.Gemini)
+	quota.Configure(cfg.Quota)
+	admin.Configure(cfg.Admin)
+	if cfg.Features.Generate {
+		azure.Configure(cfg.Azure)
+		if err := extraction.Configure(cfg.Unioffice); err != nil {
+			fatal("DOCX の初期化に失敗しました", err)
+		}
+	}
+
+	// /ready で確認する依存サービス（無効な機能の分は見ない）
+	health.Register("supabase", supabase.Ping)
+	if cfg.Features.Chat || cfg.Features.Generate {
+		health.Register("gemini", gemini.Ping)
+	}
+	if cfg.Features.Generate {
+		health.Register("azure_blob", azure.Ping)
+		health.Register("prompt_file", func(context.Context) error { return gemini.CheckPrompt() })
+	}
+}
+
+// LINE のイベントをルーターの Request に変換して処理する
+func handleEvent(ctx context.Context, rt *router.Router, bot *linebot.Client, ev *linebot.Event) {
+	req := &router.Request{
+		UserID:  ev.Source.UserID,
+		Replier: lineReplier{bot: bot, replyToken: ev.ReplyToken},
 	}
+
+	switch ev.Type {
+	case linebot.EventTypeFollow:
+		req.Kind = router.KindFollow
+	case linebot.EventTypeMessage:
+		switch msg := ev.Message.(type) {
+		case *linebot.TextMessage:
+			req.Kind = router.KindText
+			req.Text = strings.TrimSpace(msg.Text)
+		case *linebot.FileMessage:
+			req.Kind = router.KindFile
+			req.MessageID = msg.ID
+			req.FileName = msg.FileName
+		case *linebot.ImageMessage:
+			// 外部URLの画像は LINE から取得できない
+			if msg.ContentProvider != nil && msg.ContentProvider.Type != linebot.ContentProviderTypeLINE {
+				return
+			}
+			req.Kind = router.KindImage
+			req.MessageID = msg.ID
+		default:
+			return
+		}
+	case linebot.EventTypePostback:
+		// クイックリプライ・リッチメニューのボタン（コマンドと同じ処理）
+		req.Kind = router.KindPostback
+		req.Data = ev.Postback.Data
+	default:
+		return
+	}
+
+	rt.Dispatch(ctx, req)
 }
 
 func main() {
+	setup()
 
+	// LINE API の呼び出しもトレースに載せる（パスのユーザーIDは伏せる）
 	bot, err := linebot.New(
-		LINE_CHANNEL_SECRET,
-		LINE_CHANNEL_ACCESS_TOKEN,
+		cfg.LINE.ChannelSecret.Value(),
+		cfg.LINE.ChannelAccessToken.Value(),
+		linebot.WithHTTPClient(&http.Client{Transport: tracing.RedactedTransport(nil, redactLineIDs)}),
 	)
 	if err != nil {
-		log.Fatal(err)
+		fatal("LINE クライアントの初期化に失敗しました", err)
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "10000"
+	if cfg.Features.RichMenu {
+		// 失敗してもテキストのコマンド・クイックリプライで操作できるので起動は続ける
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := richmenu.Provision(ctx, bot, cfg.RichMenu); err != nil {
+			slog.Warn("リッチメニューの作成に失敗しました", logging.Err(err))
+		}
+		cancel()
 	}
 
-	http.HandleFunc("/health", func(w http.ResponseWriter, _ *http.Request) {
+	rt := newRouter(router.NewMemoryModes(), newTemplateStore())
+
+	port := cfg.Port
+	mux := http.NewServeMux()
+
+	// 生存確認（依存サービスは見ない）
+	mux.HandleFunc("/health", func(w http.ResponseWriter, _ *http.Request) {
 		w.Write([]byte("OK"))
 	})
 
-	http.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+	// 受付可能か（Supabase・Azure・Gemini・プロンプト）
+	mux.Handle("/ready", health.Handler())
+
+	if cfg.Features.Metrics {
+		mux.Handle("/metrics", metrics.Handler(cfg.Metrics.Token.Value()))
+	}
+
+	if cfg.Features.Admin {
+		mux.Handle("/admin/", admin.Handler())
+	}
+
+	mux.Handle("/callback", tracing.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		events, err := bot.ParseRequest(r)
 		if err != nil {
@@ -79,182 +227,86 @@ func main() {
 			return
 		}
 
-		for _, ev := range events {
+		inflight.Add(1)
+		defer inflight.Done()
 
-			switch ev.Type {
-
-			// ================= フォロー =================
-			case linebot.EventTypeFollow:
-				userID := ev.Source.UserID
-
-				exists, err := supabase.IsUser(userID)
-				if err != nil {
-					reply(bot, ev, "通信エラーが発生しました")
-					continue
-				}
-
-				if !exists {
-					reply(bot, ev, "このAIは購入者限定です。\n認証コードを送信してください")
-					continue
-				}
-
-				reply(bot, ev, "認証済みです。\n#会話\n#生成\nから選択してください")
-
-			// ================= メッセージ =================
-			case linebot.EventTypeMessage:
-				userID := ev.Source.UserID
-
-				switch msg := ev.Message.(type) {
-
-				// ---------- テキスト ----------
-				case *linebot.TextMessage:
-					text := strings.TrimSpace(msg.Text)
-					log.Println("TEXT:", userID, text)
-
-					exists, err := supabase.IsUser(userID)
-					if err != nil {
-						reply(bot, ev, "通信エラーが発生しました")
-						continue
-					}
-
-					// 未認証
-					if !exists {
-						ok, err := supabase.UseAuthCode(text)
-						if err != nil {
-							reply(bot, ev, "通信エラーが発生しました")
-							continue
-						}
-
-						if ok {
-							_ = supabase.AddUser(userID)
-							reply(bot, ev, "認証完了しました。\n#会話\n#生成\nを選択してください")
-						} else {
-							reply(bot, ev, "認証コードが正しくありません")
-						}
-						continue
-					}
-
-					// モード切替
-					if text == "#会話" {
-						userMode[userID] = "chat"
-						reply(bot, ev, "会話モードに切り替えました")
-						continue
-					}
-
-					if text == "#生成" {
-						userMode[userID] = "generate"
-						delete(templatePath, userID)
-						delete(templateJSON, userID)
-						reply(bot, ev, "生成モードです。\nWordテンプレート（.docx）を送信してください")
-						continue
-					}
-
-					mode := userMode[userID]
-					if mode == "" {
-						reply(bot, ev, "#会話 または #生成 を選択してください")
-						continue
-					}
-
-					// ---------- 会話モード ----------
-					if mode == "chat" {
-						out, err := gemini.ChatAiSystem(text)
-						if err != nil {
-							reply(bot, ev, "AI応答に失敗しました")
-							continue
-						}
-						reply(bot, ev, out)
-						continue
-					}
-
-					// ---------- 生成モード ----------
-					if mode == "generate" {
-
-						if templateJSON[userID] == "" {
-							reply(bot, ev, "先に Wordテンプレート（.docx）を送信してください")
-							continue
-						}
-
-						out, err := gemini.GenerateAiSystem(
-							templateJSON[userID],
-							text,
-						)
-						if err != nil {
-							log.Println(err)
-							reply(bot, ev, "生成に失敗しました")
-							continue
-						}
-
-						container:="documents"
-						blobName:=filepath.Base(out)
-
-						err=azure.UploadDocx(container,blobName,out)
-						if err!=nil {
-							log.Println(err)
-							reply(bot,ev,"faileのアップロードに失敗しました")
-							continue
-						}
-
-						sasUrl,err:=azure.GenerateBlobSASURL(container,blobName,5)
-
-						replyFile(bot, ev, sasUrl)
-						continue
-					}
-
-				// ---------- Wordファイル ----------
-				case *linebot.FileMessage:
-					log.Println("FILE:", msg.FileName, userID)
-
-					if userMode[userID] != "generate" {
-						reply(bot, ev, "ファイル送信は生成モードで行ってください")
-						continue
-					}
-
-					if !strings.HasSuffix(strings.ToLower(msg.FileName), ".docx") {
-						reply(bot, ev, "対応しているのは Word（.docx）のみです")
-						continue
-					}
-
-					content, err := bot.GetMessageContent(msg.ID).Do()
-					if err != nil {
-						reply(bot, ev, "ファイル取得に失敗しました")
-						continue
-					}
-					defer content.Content.Close()
-
-					path := "/tmp/" + userID + "_template.docx"
-
-					f, err := os.Create(path)
-					if err != nil {
-						reply(bot, ev, "ファイル保存に失敗しました")
-						continue
-					}
-					defer f.Close()
-
-					if _, err := io.Copy(f, content.Content); err != nil {
-						reply(bot, ev, "ファイル書き込みに失敗しました")
-						continue
-					}
-
-					// ★ Word構造抽出
-					docStruct, err :=extraction.ExtractWordStructure(path)
-					if err != nil {
-						reply(bot, ev, "Word構造の解析に失敗しました")
-						continue
-					}
-
-					jsonBytes, _ := json.MarshalIndent(docStruct, "", "  ")
-					templateJSON[userID] = string(jsonBytes)
-					templatePath[userID] = path
-
-					reply(bot, ev,
-						"✅ Wordテンプレートを解析しました\n"+
-							"次に【研究内容】を送信してください",
-					)
-				}
-			}
+		for _, ev := range events {
+			// イベントごとに相関IDを付け、以降の処理（Supabase・Gemini・Azure）へ引き継ぐ
+			ctx := logging.NewContext(r.Context(), ev.WebhookEventID)
+			ctx = logging.WithUser(ctx, ev.Source.UserID)
+			ctx, span := tracing.Start(ctx, "line.event",
+				attribute.String("line.event.type", string(ev.Type)),
+				attribute.String("correlation_id", logging.CorrelationID(ctx)),
+			)
+			slog.InfoContext(ctx, "イベント受信", "type", ev.Type)
+			metrics.WebhookEvent(string(ev.Type))
+
+			handleEvent(ctx, rt, bot, ev)
+			span.End()
 		}
-	})
+	}), "webhook"))
+
+	// 停止猶予を過ぎたら下流の呼び出し（Gemini・Azure 等）を打ち切る
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+
+	srv := &http.Server{
+		Addr:              ":" + port,
+		Handler:           mux,
+		ReadHeaderTimeout: cfg.HTTP.ReadTimeout,
+		ReadTimeout:       cfg.HTTP.ReadTimeout,
+		WriteTimeout:      cfg.HTTP.WriteTimeout,
+		IdleTimeout:       cfg.HTTP.IdleTimeout,
+		BaseContext:       func(net.Listener) context.Context { return baseCtx },
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+
+	sigCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	errCh := make(chan error, 1)
+	go func() {
+		slog.Info("待ち受けを開始します", "port", port)
+		errCh <- srv.ListenAndServe()
+	}()
 
-	log.Println("Listening on :" + port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	select {
+	case err := <-errCh:
+		fatal("HTTP サーバーが停止しました", err)
+	case <-sigCtx.Done():
+	}
+	stop() // 2回目のシグナルは即時終了
+
+	slog.Info("停止シグナルを受信しました。処理中のリクエストを待ちます", "timeout", cfg.HTTP.ShutdownTimeout.String())
+	health.Drain()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		// 打ち切った処理がジョブ失敗・利用枠の返却を記録し終えるまで少し待つ
+		slog.Warn("猶予時間内に終わらなかった処理を打ち切ります", logging.Err(err))
+		cancelBase()
+		waitInflight(10 * time.Second)
+	}
+
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Warn("トレースの送信に失敗しました", logging.Err(err))
+	}
+	slog.Info("停止しました")
+}
+
+// 処理中の Webhook が終わるのを最大 d 待つ
+func waitInflight(d time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(d):
+		slog.Warn("処理中の Webhook を待たずに終了します")
+	}
 }
@@ -0,0 +1,183 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+/* =======================
+   Prometheus メトリクス
+======================= */
+
+const namespace = "lineai"
+
+var registry = prometheus.NewRegistry()
+
+// 外部API呼び出し用（数百ms〜数十秒）
+var slowBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120}
+
+var (
+	webhookEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_events_total",
+		Help:      "LINE Webhook で受信したイベント数（種類別）",
+	}, []string{"type"})
+
+	geminiRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "gemini_requests_total",
+		Help:      "Gemini 呼び出し回数（result=success/failure）",
+	}, []string{"model", "mode", "result"})
+
+	geminiDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "gemini_request_duration_seconds",
+		Help:      "Gemini 呼び出しの所要時間",
+		Buckets:   slowBuckets,
+	}, []string{"model", "mode"})
+
+	geminiTokens = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "gemini_tokens_total",
+		Help:      "Gemini の消費トークン数（kind=prompt/candidates）",
+	}, []string{"model", "mode", "kind"})
+
+	supabaseDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "supabase_request_duration_seconds",
+		Help:      "Supabase(PostgREST) リクエストの所要時間（status=0 は通信失敗）",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "resource", "status"})
+
+	azureUploadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "azure_upload_duration_seconds",
+		Help:      "Azure Blob へのアップロード所要時間",
+		Buckets:   slowBuckets,
+	}, []string{"result"})
+
+	azureUploadBytes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "azure_upload_bytes",
+		Help:      "Azure Blob にアップロードした文書サイズ",
+		Buckets:   prometheus.ExponentialBuckets(16*1024, 2, 10), // 16KiB〜8MiB
+	})
+
+	extractionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "extraction_duration_seconds",
+		Help:      "Word 構造の抽出（read）・書き出し（write）の所要時間",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"backend", "op", "result"})
+
+	extractionBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "extraction_document_bytes",
+		Help:      "抽出・書き出しした .docx のサイズ",
+		Buckets:   prometheus.ExponentialBuckets(16*1024, 2, 10),
+	}, []string{"op"})
+
+	generationJobs = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "generation_jobs_in_progress",
+		Help:      "処理中の文書生成ジョブ数",
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		webhookEvents,
+		geminiRequests, geminiDuration, geminiTokens,
+		supabaseDuration,
+		azureUploadDuration, azureUploadBytes,
+		extractionDuration, extractionBytes,
+		generationJobs,
+	)
+}
+
+// /metrics のハンドラ（token が空でなければ Bearer 認証を求める）
+func Handler(token string) http.Handler {
+	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	if token == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+func WebhookEvent(eventType string) {
+	webhookEvents.WithLabelValues(eventType).Inc()
+}
+
+// mode は chat / generate
+func Gemini(model, mode string, start time.Time, promptTokens, candidateTokens int, err error) {
+	geminiRequests.WithLabelValues(model, mode, result(err)).Inc()
+	geminiDuration.WithLabelValues(model, mode).Observe(time.Since(start).Seconds())
+	if promptTokens > 0 {
+		geminiTokens.WithLabelValues(model, mode, "prompt").Add(float64(promptTokens))
+	}
+	if candidateTokens > 0 {
+		geminiTokens.WithLabelValues(model, mode, "candidates").Add(float64(candidateTokens))
+	}
+}
+
+// path は /rest/v1/<table> や /rest/v1/rpc/<fn>（クエリは含めない）。status 0 は通信失敗。
+func Supabase(method, path string, status int, start time.Time) {
+	supabaseDuration.WithLabelValues(method, resource(path), strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+}
+
+// ラベルの種類が増えすぎないようテーブル名・関数名だけにする
+func resource(path string) string {
+	p := strings.TrimPrefix(path, "/rest/v1/")
+	if strings.HasPrefix(p, "rpc/") {
+		return p
+	}
+	if i := strings.Index(p, "/"); i >= 0 {
+		p = p[:i]
+	}
+	return p
+}
+
+func AzureUpload(size int64, start time.Time, err error) {
+	azureUploadDuration.WithLabelValues(result(err)).Observe(time.Since(start).Seconds())
+	if err == nil {
+		azureUploadBytes.Observe(float64(size))
+	}
+}
+
+// op は read / write。size が分からなければ 0。
+func Extraction(backend, op string, size int64, start time.Time, err error) {
+	extractionDuration.WithLabelValues(backend, op, result(err)).Observe(time.Since(start).Seconds())
+	if err == nil && size > 0 {
+		extractionBytes.WithLabelValues(op).Observe(float64(size))
+	}
+}
+
+// 生成ジョブの開始。戻り値の関数で終了を記録する。
+func GenerationStarted() (done func()) {
+	generationJobs.Inc()
+	return generationJobs.Dec
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"go_project/authguard"
+	"go_project/logging"
+	"go_project/richmenu"
+	"go_project/router"
+	"go_project/supabase"
+	"go_project/usercache"
+)
+
+/* =======================
+   ミドルウェア
+======================= */
+
+// 受信内容と処理時間を記録する（本文・ファイル名は伏せ字）
+func logMiddleware(next router.Handler) router.Handler {
+	return router.HandlerFunc(func(ctx context.Context, req *router.Request) {
+		start := time.Now()
+		attrs := []any{"kind", req.Kind, "mode", req.Mode}
+		switch req.Kind {
+		case router.KindText:
+			attrs = append(attrs, logging.Text("text", req.Text))
+			if req.Command != "" {
+				attrs = append(attrs, "command", req.Command)
+			}
+		case router.KindFile:
+			attrs = append(attrs, logging.Text("file_name", req.FileName))
+		case router.KindPostback:
+			attrs = append(attrs, "command", req.Command)
+		}
+		slog.InfoContext(ctx, "メッセージ受信", attrs...)
+
+		next.Handle(ctx, req)
+
+		slog.DebugContext(ctx, "メッセージ処理完了", "duration_ms", time.Since(start).Milliseconds())
+	})
+}
+
+/* ---------- 連投の制限 ---------- */
+
+var (
+	// ユーザーごとに連続で受け付ける件数
+	MessageBurst = 10.0
+	// 1秒あたりに回復する件数
+	MessageRate = 0.5
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+var (
+	rateMu  sync.Mutex
+	buckets = map[string]*bucket{}
+)
+
+// 上限を超えたら待ち時間を返す
+func takeMessageToken(userID string, now time.Time) (time.Duration, bool) {
+	rateMu.Lock()
+	defer rateMu.Unlock()
+
+	b, ok := buckets[userID]
+	if !ok {
+		b = &bucket{tokens: MessageBurst, last: now}
+		buckets[userID] = b
+		if len(buckets) > 10000 {
+			pruneBuckets(now)
+		}
+	}
+	b.tokens = math.Min(MessageBurst, b.tokens+now.Sub(b.last).Seconds()*MessageRate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / MessageRate * float64(time.Second)), false
+	}
+	b.tokens--
+	return 0, true
+}
+
+// 満タンまで回復したバケットは捨てる
+func pruneBuckets(now time.Time) {
+	for id, b := range buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*MessageRate >= MessageBurst {
+			delete(buckets, id)
+		}
+	}
+}
+
+func rateLimitMiddleware(next router.Handler) router.Handler {
+	return router.HandlerFunc(func(ctx context.Context, req *router.Request) {
+		if req.Kind != router.KindFollow {
+			if wait, ok := takeMessageToken(req.UserID, time.Now()); !ok {
+				slog.WarnContext(ctx, "連投を制限しました", "retry_after", wait.Round(time.Second).String())
+				req.ReplyText(ctx, "送信が多すぎます。少し時間をおいてから再度お試しください")
+				return
+			}
+		}
+		next.Handle(ctx, req)
+	})
+}
+
+/* ---------- 認証 ---------- */
+
+// 購入者かを確認し、req.User を設定する。
+// 未認証・期限切れのテキストは認証コードとして扱う（期限切れの #コマンドを除く）。
+func authMiddleware(next router.Handler) router.Handler {
+	return router.HandlerFunc(func(ctx context.Context, req *router.Request) {
+		user, err := usercache.Get(ctx, req.UserID)
+		if err != nil {
+			req.ReplyText(ctx, "通信エラーが発生しました")
+			return
+		}
+
+		if req.Kind == router.KindText {
+			expired := user != nil && user.Status(time.Now()) == supabase.UserExpired
+			if user == nil || (expired && !strings.HasPrefix(req.Text, "#")) {
+				redeemCode(ctx, req, req.Text)
+				return
+			}
+		}
+
+		if user == nil {
+			req.ReplyText(ctx, "このAIは購入者限定です。\n認証コードを送信してください")
+			return
+		}
+		if denyInactive(ctx, req, user) {
+			return
+		}
+
+		req.User = user
+		richmenu.ShowMember(ctx, req.UserID)
+		next.Handle(ctx, req)
+	})
+}
+
+// 利用枠を消費する前に最新の状態を確かめる（cmd/users や DB で直接行った利用停止はキャッシュに届かないため）。
+// 続けてよければ true。
+func recheckUser(ctx context.Context, req *router.Request) bool {
+	user, err := usercache.Refresh(ctx, req.UserID)
+	if err != nil {
+		req.ReplyText(ctx, "通信エラーが発生しました")
+		return false
+	}
+	if user == nil {
+		richmenu.ShowGuest(ctx, req.UserID)
+		req.ReplyText(ctx, "このAIは購入者限定です。\n認証コードを送信してください")
+		return false
+	}
+	if denyInactive(ctx, req, user) {
+		return false
+	}
+	req.User = user
+	return true
+}
+
+// 送信されたテキストを認証コードとして利用する
+func redeemCode(ctx context.Context, req *router.Request, code string) {
+	userID := req.UserID
+	if d := authguard.Allow(ctx, userID); !d.Allowed {
+		req.ReplyText(ctx, "認証の試行回数が多すぎます。\n"+waitText(d.RetryAfter)+"後に再度お試しください")
+		return
+	}
+
+	result, err := supabase.RedeemAuthCode(ctx, code, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "認証コード照合失敗", logging.Err(err))
+		req.ReplyText(ctx, "通信エラーが発生しました")
+		return
+	}
+
+	switch result {
+	case supabase.RedeemSuccess:
+		authguard.Success(userID)
+		richmenu.ShowMember(ctx, userID)
+		req.Send(ctx, modeReply("認証完了しました。\n#会話\n#生成\nを選択してください"))
+		return
+	case supabase.RedeemRevoked:
+		req.ReplyText(ctx, "このアカウントは利用停止されています。\n販売元にお問い合わせください")
+		return
+	}
+
+	if lock := authguard.Failure(ctx, userID); lock > 0 {
+		req.ReplyText(ctx, "認証に続けて失敗したため、"+waitText(lock)+"ロックしました")
+		return
+	}
+
+	switch result {
+	case supabase.RedeemAlreadyUsed:
+		req.ReplyText(ctx, "この認証コードは既に使用されています")
+	case supabase.RedeemExpired:
+		req.ReplyText(ctx, "この認証コードは有効期限が切れています")
+	default:
+		req.ReplyText(ctx, "認証コードが正しくありません")
+	}
+}
+
+// 期限切れ・利用停止なら案内を返して true
+func denyInactive(ctx context.Context, req *router.Request, user *supabase.User) bool {
+	status := user.Status(time.Now())
+	if status == supabase.UserRevoked || status == supabase.UserExpired {
+		richmenu.ShowGuest(ctx, req.UserID)
+	}
+	switch status {
+	case supabase.UserRevoked:
+		req.ReplyText(ctx, "このアカウントは利用停止されています。\n販売元にお問い合わせください")
+		return true
+	case supabase.UserExpired:
+		req.ReplyText(ctx, "利用期限が切れています（"+user.ExpiresAt.In(jst).Format("2006/01/02")+"まで）。\n"+
+			"引き続き利用するには新しい認証コードを送信してください")
+		return true
+	}
+	return false
+}
+
+// ロック時間の表示（分単位で切り上げ）
+func waitText(d time.Duration) string {
+	if d >= time.Hour {
+		return fmt.Sprintf("約%d時間", int(math.Ceil(d.Hours())))
+	}
+	return fmt.Sprintf("約%d分", int(math.Ceil(d.Minutes())))
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go_project/config"
+	"go_project/router"
+	"go_project/supabase"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+// 返信を記録する Replier
+type fakeReplier struct {
+	texts []string
+}
+
+func (f *fakeReplier) Reply(_ context.Context, messages ...linebot.SendingMessage) error {
+	for _, m := range messages {
+		if t, ok := m.(*linebot.TextMessage); ok {
+			f.texts = append(f.texts, t.Text)
+		}
+	}
+	return nil
+}
+
+func (f *fakeReplier) Content(context.Context, string) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
+// ユーザーの取得と認証コードの照合だけに答える Supabase
+type fakeSupabase struct {
+	mu       sync.Mutex
+	users    map[string]supabase.User
+	lookups  int      // users の取得回数
+	redeemed []string // redeem_auth_code に渡されたコード
+}
+
+func (f *fakeSupabase) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/rest/v1/users":
+		f.lookups++
+		users := []supabase.User{}
+		if u, ok := f.users[strings.TrimPrefix(r.URL.Query().Get("line_user_id"), "eq.")]; ok {
+			users = append(users, u)
+		}
+		json.NewEncoder(w).Encode(users)
+	case "/rest/v1/rpc/redeem_auth_code":
+		var args map[string]string
+		json.NewDecoder(r.Body).Decode(&args)
+		f.redeemed = append(f.redeemed, args["p_code"])
+		json.NewEncoder(w).Encode(supabase.RedeemInvalid)
+	default:
+		// security_events など
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func (f *fakeSupabase) counts() (lookups, redeemed int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lookups, len(f.redeemed)
+}
+
+// 本番と同じルーターを Supabase の代わりに fake で動かす
+func setupRouter(t *testing.T, users ...supabase.User) (*router.Router, *fakeSupabase) {
+	t.Helper()
+	fake := &fakeSupabase{users: map[string]supabase.User{}}
+	for _, u := range users {
+		fake.users[u.LineUserID] = u
+	}
+	srv := httptest.NewServer(fake)
+	supabase.Configure(config.Supabase{URL: srv.URL, ServiceRoleKey: "test"})
+
+	prevCfg, prevBurst, prevRate := cfg, MessageBurst, MessageRate
+	cfg = &config.Config{Features: config.Features{Chat: true, Generate: true}}
+	rateMu.Lock()
+	buckets = map[string]*bucket{}
+	rateMu.Unlock()
+
+	t.Cleanup(func() {
+		srv.Close()
+		supabase.Configure(config.Supabase{})
+		cfg, MessageBurst, MessageRate = prevCfg, prevBurst, prevRate
+	})
+	return newRouter(router.NewMemoryModes(), newTemplateStore()), fake
+}
+
+func dispatch(rt *router.Router, userID string, req router.Request) []string {
+	replier := &fakeReplier{}
+	req.UserID = userID
+	req.Replier = replier
+	rt.Dispatch(context.Background(), &req)
+	return replier.texts
+}
+
+// usercache はテストをまたいで残るので、ユーザーIDはテストごとに変える
+func testUser(t *testing.T, status supabase.UserStatus) supabase.User {
+	u := supabase.User{ID: "id-" + t.Name(), LineUserID: "U-" + t.Name(), Plan: "standard"}
+	switch status {
+	case supabase.UserExpired:
+		past := time.Now().Add(-time.Hour)
+		u.ExpiresAt = &past
+	case supabase.UserRevoked:
+		now := time.Now()
+		u.RevokedAt = &now
+	}
+	return u
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	tests := []struct {
+		name   string
+		status supabase.UserStatus // 空なら未登録
+		req    router.Request
+		reply  string // 返信の先頭
+		redeem bool   // 認証コードとして照合したか
+	}{
+		{"未登録のテキストは認証コード", "", router.Request{Kind: router.KindText, Text: "CODE-1234"}, "認証コードが正しくありません", true},
+		{"未登録のコマンドも認証コード", "", router.Request{Kind: router.KindText, Text: "#生成"}, "認証コードが正しくありません", true},
+		{"未登録のボタンは案内", "", router.Request{Kind: router.KindPostback, Data: router.PostbackData("#認証", "")}, "このAIは購入者限定です", false},
+		{"未登録のファイルは案内", "", router.Request{Kind: router.KindFile, FileName: "a.docx"}, "このAIは購入者限定です", false},
+		{"登録済みはハンドラへ", supabase.UserActive, router.Request{Kind: router.KindText, Text: "#認証"}, "認証済みです", false},
+		{"登録済みでモード未選択", supabase.UserActive, router.Request{Kind: router.KindText, Text: "こんにちは"}, "#会話 または #生成 を選択してください", false},
+		{"登録済みでモード未選択のファイル", supabase.UserActive, router.Request{Kind: router.KindFile, FileName: "a.docx"}, "ファイル・画像の送信は生成モードで行ってください", false},
+		{"期限切れのコマンドは案内", supabase.UserExpired, router.Request{Kind: router.KindText, Text: "#認証"}, "利用期限が切れています", false},
+		{"期限切れのテキストは認証コード", supabase.UserExpired, router.Request{Kind: router.KindText, Text: "CODE-5678"}, "認証コードが正しくありません", true},
+		{"利用停止", supabase.UserRevoked, router.Request{Kind: router.KindText, Text: "#認証"}, "このアカウントは利用停止されています", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := testUser(t, tt.status)
+			var users []supabase.User
+			if tt.status != "" {
+				users = append(users, u)
+			}
+			rt, fake := setupRouter(t, users...)
+
+			texts := dispatch(rt, u.LineUserID, tt.req)
+			if len(texts) != 1 || !strings.HasPrefix(texts[0], tt.reply) {
+				t.Fatalf("返信 = %q, want %q…", texts, tt.reply)
+			}
+			if _, redeemed := fake.counts(); (redeemed > 0) != tt.redeem {
+				t.Errorf("認証コードの照合 = %d 回, want %v", redeemed, tt.redeem)
+			}
+		})
+	}
+}
+
+// 連投の制限は認証より外側：制限中は Supabase に問い合わせず、認証コードの試行にも数えない
+func TestRateLimitBeforeAuth(t *testing.T) {
+	t.Run("未登録", func(t *testing.T) {
+		rt, fake := setupRouter(t)
+		MessageBurst, MessageRate = 2, 0.001
+		userID := testUser(t, "").LineUserID
+
+		for i := 0; i < 2; i++ {
+			dispatch(rt, userID, router.Request{Kind: router.KindText, Text: "CODE-1234"})
+		}
+		lookups, redeemed := fake.counts()
+		if redeemed != 2 {
+			t.Fatalf("認証コードの照合 = %d 回, want 2", redeemed)
+		}
+
+		texts := dispatch(rt, userID, router.Request{Kind: router.KindText, Text: "CODE-1234"})
+		if len(texts) != 1 || !strings.HasPrefix(texts[0], "送信が多すぎます") {
+			t.Fatalf("返信 = %q, want 送信が多すぎます…", texts)
+		}
+		if l, r := fake.counts(); l != lookups || r != redeemed {
+			t.Errorf("制限中に Supabase へ問い合わせました（取得 %d→%d 回、照合 %d→%d 回）", lookups, l, redeemed, r)
+		}
+	})
+
+	t.Run("友だち追加は制限しない", func(t *testing.T) {
+		u := testUser(t, supabase.UserActive)
+		rt, _ := setupRouter(t, u)
+		MessageBurst, MessageRate = 1, 0.001
+
+		dispatch(rt, u.LineUserID, router.Request{Kind: router.KindText, Text: "#認証"})
+		texts := dispatch(rt, u.LineUserID, router.Request{Kind: router.KindText, Text: "#認証"})
+		if len(texts) != 1 || !strings.HasPrefix(texts[0], "送信が多すぎます") {
+			t.Fatalf("返信 = %q, want 送信が多すぎます…", texts)
+		}
+
+		texts = dispatch(rt, u.LineUserID, router.Request{Kind: router.KindFollow})
+		if len(texts) != 1 || !strings.HasPrefix(texts[0], "認証済みです") {
+			t.Errorf("友だち追加の返信 = %q, want 認証済みです…", texts)
+		}
+	})
+}
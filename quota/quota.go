@@ -0,0 +1,101 @@
+package quota
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go_project/config"
+	"go_project/supabase"
+)
+
+/* =======================
+   月間利用枠
+======================= */
+
+var cfg = config.Quota{ResetDay: 1}
+
+func Configure(c config.Quota) {
+	cfg = c
+}
+
+var jst = time.FixedZone("JST", 9*60*60)
+
+type Kind string
+
+const (
+	Generation Kind = supabase.QuotaGeneration
+	Chat       Kind = supabase.QuotaChat
+)
+
+// 毎月この日（日本時間0時）に利用回数がリセットされる（1〜28）
+func resetDay() int {
+	d := cfg.ResetDay
+	if d < 1 {
+		return 1
+	}
+	if d > 28 {
+		return 28
+	}
+	return d
+}
+
+// now を含む集計期間の開始日
+func PeriodStart(now time.Time) time.Time {
+	now = now.In(jst)
+	start := time.Date(now.Year(), now.Month(), resetDay(), 0, 0, 0, 0, jst)
+	if now.Before(start) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start
+}
+
+// 次にリセットされる日時
+func NextReset(now time.Time) time.Time {
+	return PeriodStart(now).AddDate(0, 1, 0)
+}
+
+// 枠が残っていれば1回分を消費して true を返す
+func Consume(ctx context.Context, lineUserID string, kind Kind) (bool, error) {
+	res, err := supabase.ConsumeQuota(ctx, lineUserID, string(kind), PeriodStart(time.Now()))
+	if err != nil {
+		return false, err
+	}
+	return res.Allowed, nil
+}
+
+// AI呼び出しに失敗したときに消費した分を戻す
+func Release(ctx context.Context, lineUserID string, kind Kind) error {
+	return supabase.ReleaseQuota(ctx, lineUserID, string(kind))
+}
+
+// #残り の表示用
+func Summary(ctx context.Context, lineUserID string) (string, error) {
+	now := time.Now()
+	usage, err := supabase.GetQuotaUsage(ctx, lineUserID, PeriodStart(now))
+	if err != nil {
+		return "", err
+	}
+	if usage == nil {
+		return "", fmt.Errorf("利用状況が見つかりません")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "プラン: %s\n", usage.Plan)
+	fmt.Fprintf(&b, "生成: %s\n", remaining(usage.GenerationsUsed, usage.GenerationsQuota))
+	fmt.Fprintf(&b, "会話: %s\n", remaining(usage.ChatsUsed, usage.ChatsQuota))
+	fmt.Fprintf(&b, "次回リセット: %s", NextReset(now).Format("2006/01/02"))
+	return b.String(), nil
+}
+
+func remaining(used int, quota *int) string {
+	if quota == nil {
+		return fmt.Sprintf("無制限（今月 %d 回利用）", used)
+	}
+	left := *quota - used
+	if left < 0 {
+		left = 0
+	}
+	return fmt.Sprintf("残り %d / %d 回", left, *quota)
+}
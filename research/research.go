@@ -0,0 +1,155 @@
+package research
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"go_project/extraction"
+	"go_project/logging"
+	"go_project/tracing"
+
+	"github.com/ledongthuc/pdf"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/text/encoding/japanese"
+)
+
+/* =======================
+   研究内容ファイルのテキスト抽出
+======================= */
+
+var (
+	// 受け付けるファイルの大きさの上限
+	MaxFileBytes int64 = 20 << 20
+	// 生成に渡す研究内容の文字数の上限（ファイル・テキストの合計）
+	MaxChars = 100000
+)
+
+var (
+	ErrUnsupported = errors.New("対応していない形式です")
+	ErrTooLarge    = errors.New("ファイルが大きすぎます")
+	ErrNoText      = errors.New("テキストが含まれていません")
+)
+
+// 対応する拡張子（案内文にも使う）
+var Extensions = []string{".docx", ".txt", ".md", ".pdf"}
+
+func Supported(fileName string) bool {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	for _, e := range Extensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// Extract はファイルの本文をテキストで返す（.docx・.txt・.md・PDF のテキスト層）
+func Extract(ctx context.Context, fileName string, r io.Reader) (_ string, err error) {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	ctx, span := tracing.Start(ctx, "research.extract", attribute.String("file.ext", ext))
+	defer func() { tracing.End(span, err) }()
+
+	if !Supported(fileName) {
+		return "", ErrUnsupported
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, MaxFileBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(data)) > MaxFileBytes {
+		return "", ErrTooLarge
+	}
+
+	var text string
+	switch ext {
+	case ".txt", ".md":
+		text, err = decodeText(data)
+	case ".docx":
+		text, err = docxText(ctx, data)
+	case ".pdf":
+		text, err = pdfText(data)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	text = strings.TrimSpace(strings.ReplaceAll(text, "\r\n", "\n"))
+	if text == "" {
+		return "", ErrNoText
+	}
+	slog.InfoContext(ctx, "研究内容ファイルを読み込みました", "ext", ext, "bytes", len(data),
+		"chars", utf8.RuneCountInString(text), logging.Text("file_name", fileName))
+	return text, nil
+}
+
+// UTF-8（BOM 付きを含む）でなければ Shift_JIS（Windows のメモ帳等）として読む
+func decodeText(data []byte) (string, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if utf8.Valid(data) {
+		return string(data), nil
+	}
+	out, err := japanese.ShiftJIS.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", fmt.Errorf("文字コードを判別できません: %w", err)
+	}
+	return string(out), nil
+}
+
+func docxText(ctx context.Context, data []byte) (string, error) {
+	f, err := os.CreateTemp("", "research-*.docx")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	doc, err := extraction.ExtractWordStructure(ctx, f.Name())
+	if err != nil {
+		return "", err
+	}
+	return extraction.PlainText(doc), nil
+}
+
+// スキャンした画像だけの PDF はテキストがないので ErrNoText になる
+func pdfText(data []byte) (text string, err error) {
+	// 壊れた PDF でパニックすることがあるのでエラーにする
+	defer func() {
+		if p := recover(); p != nil {
+			text, err = "", fmt.Errorf("PDF の解析に失敗しました: %v", p)
+		}
+	}()
+
+	r, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("PDF の解析に失敗しました: %w", err)
+	}
+	var sb strings.Builder
+	for i := 1; i <= r.NumPage(); i++ {
+		p := r.Page(i)
+		if p.V.IsNull() {
+			continue
+		}
+		s, err := p.GetPlainText(nil)
+		if err != nil {
+			return "", fmt.Errorf("PDF %dページ目: %w", i, err)
+		}
+		sb.WriteString(s)
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,96 @@
+package research
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func extractFile(t *testing.T, name string) (string, error) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Extract(context.Background(), name, bytes.NewReader(data))
+}
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		file string
+		want []string // 含まれる文字列
+		err  error
+	}{
+		{"sjis.txt", []string{"研究内容：プラズマの測定\n結果は良好"}, nil},
+		{"bom.md", []string{"# 研究", "UTF-8 本文"}, nil},
+		{"research.docx", []string{"プラズマ処理の研究", "目的：表面改質の効果を調べる", "条件A"}, nil},
+		{"text.pdf", []string{"Plasma etching results"}, nil},
+		{"blank.txt", nil, ErrNoText},
+		{"blank.pdf", nil, ErrNoText}, // スキャンした画像だけの PDF と同じ
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			text, err := extractFile(t, tt.file)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(text, w) {
+					t.Errorf("text = %q, want %q を含む", text, w)
+				}
+			}
+			if strings.HasPrefix(text, "\ufeff") || strings.Contains(text, "\r") {
+				t.Errorf("BOM・CR が残っています: %q", text)
+			}
+		})
+	}
+}
+
+// 壊れた PDF でライブラリがパニックしてもエラーで返す
+func TestExtractBrokenPDF(t *testing.T) {
+	_, err := extractFile(t, "broken.pdf")
+	if err == nil || !strings.Contains(err.Error(), "PDF の解析に失敗しました") {
+		t.Fatalf("err = %v, want PDF の解析に失敗しました", err)
+	}
+	if _, err := Extract(context.Background(), "x.pdf", strings.NewReader("%PDF-1.4 truncated")); err == nil {
+		t.Error("途中で切れた PDF を読めてしまいました")
+	}
+}
+
+func TestDecodeText(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"UTF-8", []byte("研究"), "研究"},
+		{"BOM 付き UTF-8", []byte("\xef\xbb\xbf研究"), "研究"},
+		{"Shift_JIS", []byte{0x8c, 0xa4, 0x8b, 0x86}, "研究"},
+		{"ASCII", []byte("plain"), "plain"},
+	}
+	for _, tt := range tests {
+		got, err := decodeText(tt.data)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: decodeText = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestExtractLimits(t *testing.T) {
+	defer func(n int64) { MaxFileBytes = n }(MaxFileBytes)
+	MaxFileBytes = 16
+
+	if _, err := Extract(context.Background(), "a.txt", strings.NewReader(strings.Repeat("a", 17))); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("上限を超えたファイル: err = %v, want ErrTooLarge", err)
+	}
+	if text, err := Extract(context.Background(), "a.TXT", strings.NewReader(strings.Repeat("a", 16))); err != nil || len(text) != 16 {
+		t.Errorf("上限ちょうどのファイル: %q, %v", text, err)
+	}
+	if _, err := Extract(context.Background(), "a.xlsx", strings.NewReader("x")); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("対応していない形式: err = %v, want ErrUnsupported", err)
+	}
+}
@@ -0,0 +1,298 @@
+package richmenu
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go_project/config"
+	"go_project/logging"
+	"go_project/router"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+/* =======================
+   リッチメニュー
+======================= */
+
+// 作成したメニューの名前の接頭辞（これ以外のメニューには触れない）
+const namePrefix = "lineai-"
+
+const (
+	width  = 2500
+	height = 843
+)
+
+// メニューの定義（区画ごとに押すと実行されるコマンド）
+type Menu struct {
+	Key         string // 名前・画像ファイル名に使う
+	ChatBarText string
+	Buttons     []Button
+}
+
+type Button struct {
+	Label   string // 押したときにトークに表示する文言
+	Command string
+	Color   color.RGBA // 画像がないときの区画の色
+}
+
+var (
+	// 未認証・期限切れのユーザー（既定のメニュー）
+	Guest = Menu{
+		Key:         "guest",
+		ChatBarText: "メニュー",
+		Buttons: []Button{
+			{Label: "認証について", Command: "#認証", Color: color.RGBA{0x6c, 0x75, 0x7d, 0xff}},
+		},
+	}
+	// 認証済みのユーザー
+	Member = Menu{
+		Key:         "member",
+		ChatBarText: "メニュー",
+		Buttons: []Button{
+			{Label: "#会話", Command: "#会話", Color: color.RGBA{0x06, 0xc7, 0x55, 0xff}},
+			{Label: "#生成", Command: "#生成", Color: color.RGBA{0x1e, 0x88, 0xe5, 0xff}},
+			{Label: "#残り", Command: "#残り", Color: color.RGBA{0xf5, 0x9e, 0x0b, 0xff}},
+		},
+	}
+)
+
+// 横に等分した区画
+func (m Menu) definition() linebot.RichMenu {
+	rm := linebot.RichMenu{
+		Size:        linebot.RichMenuSize{Width: width, Height: height},
+		Selected:    false,
+		ChatBarText: m.ChatBarText,
+	}
+	for i, b := range m.Buttons {
+		x0 := width * i / len(m.Buttons)
+		x1 := width * (i + 1) / len(m.Buttons)
+		rm.Areas = append(rm.Areas, linebot.AreaDetail{
+			Bounds: linebot.RichMenuBounds{X: x0, Y: 0, Width: x1 - x0, Height: height},
+			Action: linebot.RichMenuAction{
+				Type:        linebot.RichMenuActionTypePostback,
+				Data:        router.PostbackData(b.Command, ""),
+				DisplayText: b.Label,
+			},
+		})
+	}
+	return rm
+}
+
+// 区画ごとに塗り分けた画像（文字は描かないので、本番では RICH_MENU_IMAGE_DIR に用意する）
+func (m Menu) placeholder() ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i, b := range m.Buttons {
+		x0 := width * i / len(m.Buttons)
+		x1 := width * (i + 1) / len(m.Buttons)
+		draw.Draw(img, image.Rect(x0, 0, x1, height), &image.Uniform{b.Color}, image.Point{}, draw.Src)
+		// 区画の境目
+		if i > 0 {
+			draw.Draw(img, image.Rect(x0-4, 0, x0+4, height), image.White, image.Point{}, draw.Src)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+/* ---------- 作成・更新 ---------- */
+
+var (
+	mu       sync.Mutex
+	bot      *linebot.Client
+	memberID string
+	shown    = map[string]shownMenu{} // ユーザーごとに表示中のメニュー
+	clock    = time.Now
+)
+
+type shownMenu struct {
+	key string // guest / member
+	at  time.Time
+}
+
+// メニューを作成し、未認証メニューを既定にする。
+// 定義・画像が変わっていなければ既存のメニューを使い、古いメニューは削除する。
+func Provision(ctx context.Context, client *linebot.Client, c config.RichMenu) error {
+	existing, err := client.GetRichMenuList().WithContext(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("リッチメニュー一覧の取得: %w", err)
+	}
+
+	ids := map[string]string{}
+	keep := map[string]bool{}
+	for _, m := range []Menu{Guest, Member} {
+		id, err := ensure(ctx, client, c, m, existing)
+		if err != nil {
+			return fmt.Errorf("リッチメニュー %s: %w", m.Key, err)
+		}
+		ids[m.Key] = id
+		keep[id] = true
+	}
+
+	if _, err := client.SetDefaultRichMenu(ids[Guest.Key]).WithContext(ctx).Do(); err != nil {
+		return fmt.Errorf("既定のリッチメニューの設定: %w", err)
+	}
+
+	// 以前の定義で作ったメニュー（紐付いていたユーザーは既定に戻る）
+	for _, rm := range existing {
+		if strings.HasPrefix(rm.Name, namePrefix) && !keep[rm.RichMenuID] {
+			if _, err := client.DeleteRichMenu(rm.RichMenuID).WithContext(ctx).Do(); err != nil {
+				slog.WarnContext(ctx, "古いリッチメニューの削除に失敗", "name", rm.Name, logging.Err(err))
+				continue
+			}
+			slog.InfoContext(ctx, "古いリッチメニューを削除しました", "name", rm.Name)
+		}
+	}
+
+	mu.Lock()
+	bot = client
+	memberID = ids[Member.Key]
+	shown = map[string]shownMenu{}
+	mu.Unlock()
+	return nil
+}
+
+func ensure(ctx context.Context, client *linebot.Client, c config.RichMenu, m Menu, existing []*linebot.RichMenuResponse) (string, error) {
+	img, path, err := loadImage(c.ImageDir, m)
+	if err != nil {
+		return "", err
+	}
+
+	def := m.definition()
+	def.Name = name(m, def, img)
+	for _, rm := range existing {
+		if rm.Name == def.Name {
+			return rm.RichMenuID, nil
+		}
+	}
+
+	res, err := client.CreateRichMenu(def).WithContext(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+
+	if path == "" {
+		f, err := os.CreateTemp("", "richmenu-*.png")
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(f.Name())
+		_, err = f.Write(img)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return "", err
+		}
+		path = f.Name()
+	}
+	if _, err := client.UploadRichMenuImage(res.RichMenuID, path).WithContext(ctx).Do(); err != nil {
+		// 画像のないメニューは表示できないので残さない
+		client.DeleteRichMenu(res.RichMenuID).WithContext(context.WithoutCancel(ctx)).Do()
+		return "", fmt.Errorf("画像のアップロード: %w", err)
+	}
+
+	slog.InfoContext(ctx, "リッチメニューを作成しました", "name", def.Name, "id", res.RichMenuID)
+	return res.RichMenuID, nil
+}
+
+// ImageDir に <key>.png があれば使う
+func loadImage(dir string, m Menu) (img []byte, path string, err error) {
+	if dir == "" {
+		img, err = m.placeholder()
+		return img, "", err
+	}
+	path = filepath.Join(dir, m.Key+".png")
+	img, err = os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	return img, path, nil
+}
+
+// 定義と画像のハッシュを名前に含め、変更があれば作り直す
+func name(m Menu, def linebot.RichMenu, img []byte) string {
+	h := sha256.New()
+	json.NewEncoder(h).Encode(def)
+	h.Write(img)
+	return namePrefix + m.Key + "-" + hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+/* ---------- ユーザーごとの切り替え ---------- */
+
+// 認証済みメニューを表示する（プロセス内で一度だけ API を呼ぶ）
+func ShowMember(ctx context.Context, userID string) {
+	mu.Lock()
+	client, id, cur := bot, memberID, shown[userID]
+	mu.Unlock()
+	if client == nil || cur.key == Member.Key {
+		return
+	}
+
+	if _, err := client.LinkUserRichMenu(userID, id).WithContext(ctx).Do(); err != nil {
+		slog.WarnContext(ctx, "リッチメニューの切り替えに失敗", "menu", Member.Key, logging.Err(err))
+		return
+	}
+	remember(userID, Member.Key)
+}
+
+// 既定（未認証）のメニューに戻す
+func ShowGuest(ctx context.Context, userID string) {
+	mu.Lock()
+	client, cur := bot, shown[userID]
+	mu.Unlock()
+	if client == nil || cur.key == Guest.Key {
+		return
+	}
+
+	if _, err := client.UnlinkUserRichMenu(userID).WithContext(ctx).Do(); err != nil {
+		slog.WarnContext(ctx, "リッチメニューの切り替えに失敗", "menu", Guest.Key, logging.Err(err))
+		return
+	}
+	remember(userID, Guest.Key)
+}
+
+const (
+	maxShown    = 10000
+	shownMaxAge = 24 * time.Hour
+)
+
+// 表示中のメニューを覚える（忘れても次の切り替えで API を 1 回余分に呼ぶだけ）
+func remember(userID, key string) {
+	mu.Lock()
+	defer mu.Unlock()
+	now := clock()
+	shown[userID] = shownMenu{key: key, at: now}
+	if len(shown) > maxShown {
+		pruneShown(now)
+	}
+}
+
+// shownMaxAge より前に切り替えたユーザーを忘れる。それでも多すぎれば全部忘れる（mu を保持して呼ぶ）
+func pruneShown(now time.Time) {
+	for id, m := range shown {
+		if now.Sub(m.at) >= shownMaxAge {
+			delete(shown, id)
+		}
+	}
+	if len(shown) > maxShown {
+		shown = map[string]shownMenu{}
+	}
+}
@@ -0,0 +1,93 @@
+package richmenu
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+// 切り替えの API 呼び出しを数える LINE API に向け、時計を止める
+func setup(t *testing.T) (calls func() int, advance func(time.Duration)) {
+	t.Helper()
+	var cmu sync.Mutex
+	n := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cmu.Lock()
+		n++
+		cmu.Unlock()
+		w.Write([]byte("{}"))
+	}))
+	client, err := linebot.New("secret", "token", linebot.WithEndpointBase(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	mu.Lock()
+	bot, memberID, shown = client, "member-id", map[string]shownMenu{}
+	mu.Unlock()
+	clock = func() time.Time { return now }
+	t.Cleanup(func() {
+		srv.Close()
+		mu.Lock()
+		bot, memberID, shown = nil, "", map[string]shownMenu{}
+		mu.Unlock()
+		clock = time.Now
+	})
+	return func() int {
+		cmu.Lock()
+		defer cmu.Unlock()
+		return n
+	}, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestShowOncePerMenu(t *testing.T) {
+	calls, _ := setup(t)
+	ctx := context.Background()
+
+	ShowMember(ctx, "U1")
+	ShowMember(ctx, "U1")
+	if n := calls(); n != 1 {
+		t.Fatalf("API %d 回, want 1（2 回目は表示中）", n)
+	}
+	ShowGuest(ctx, "U1")
+	ShowGuest(ctx, "U1")
+	if n := calls(); n != 2 {
+		t.Errorf("API %d 回, want 2", n)
+	}
+}
+
+// 覚えておくユーザーの数には上限がある
+func TestShownIsPruned(t *testing.T) {
+	_, advance := setup(t)
+	ctx := context.Background()
+
+	for i := 0; i < maxShown; i++ {
+		ShowMember(ctx, fmt.Sprintf("old%d", i))
+	}
+	advance(shownMaxAge)
+	ShowMember(ctx, "U1")
+	mu.Lock()
+	n, cur := len(shown), shown["U1"].key
+	mu.Unlock()
+	if n != 1 || cur != Member.Key {
+		t.Fatalf("古いユーザーを忘れていません: %d 件, U1 = %q", n, cur)
+	}
+
+	// 最近のユーザーだけで上限を超えたら全部忘れる
+	for i := 0; i < maxShown; i++ {
+		ShowMember(ctx, fmt.Sprintf("new%d", i))
+	}
+	mu.Lock()
+	n = len(shown)
+	mu.Unlock()
+	if n > maxShown {
+		t.Errorf("%d 件, want %d 件以下", n, maxShown)
+	}
+}
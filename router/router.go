@@ -0,0 +1,258 @@
+package router
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+
+	"go_project/logging"
+	"go_project/supabase"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+/* =======================
+   LINE メッセージのルーティング
+======================= */
+
+// イベントの種類
+const (
+	KindFollow   = "follow"
+	KindText     = "text"
+	KindFile     = "file"
+	KindImage    = "image"    // 写真（モードのファイル処理に渡す）
+	KindPostback = "postback" // クイックリプライ・リッチメニューのボタン
+)
+
+// 返信と受信コンテンツの取得（テストでは LINE クライアントの代わりを渡せる）
+type Replier interface {
+	Reply(ctx context.Context, messages ...linebot.SendingMessage) error
+	Content(ctx context.Context, messageID string) (io.ReadCloser, error)
+}
+
+// 1件のイベント
+type Request struct {
+	Kind   string
+	UserID string
+
+	Text    string // テキスト全体（前後の空白を除く）
+	Command string // # で始まる場合の先頭語（例 #生成）
+	Args    string // コマンドに続く文字列
+	Data    string // ポストバックの data（PostbackData で作る）
+
+	MessageID string // ファイル・画像のコンテンツ取得用
+	FileName  string
+
+	Mode string         // 処理時点のモード
+	User *supabase.User // 認証ミドルウェアが設定する
+
+	Replier Replier
+	Modes   ModeStore
+}
+
+// テキストを返信する（失敗はログのみ）
+func (r *Request) ReplyText(ctx context.Context, text string) {
+	r.Send(ctx, linebot.NewTextMessage(text))
+}
+
+// メッセージを返信する（失敗はログのみ）
+func (r *Request) Send(ctx context.Context, messages ...linebot.SendingMessage) {
+	if err := r.Replier.Reply(ctx, messages...); err != nil {
+		slog.ErrorContext(ctx, "返信失敗", logging.Err(err))
+	}
+}
+
+// モードを切り替える
+func (r *Request) SetMode(mode string) {
+	r.Mode = mode
+	r.Modes.SetMode(r.UserID, mode)
+}
+
+type Handler interface {
+	Handle(ctx context.Context, req *Request)
+}
+
+type HandlerFunc func(ctx context.Context, req *Request)
+
+func (f HandlerFunc) Handle(ctx context.Context, req *Request) { f(ctx, req) }
+
+// ハンドラを包んで前後処理を足す
+type Middleware func(next Handler) Handler
+
+// mws を外側から順に適用する
+func Chain(h Handler, mws ...Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// ParseCommand は「#生成 追加の指示」を ("#生成", "追加の指示") に分ける
+func ParseCommand(text string) (command, args string) {
+	if !strings.HasPrefix(text, "#") {
+		return "", ""
+	}
+	i := strings.IndexAny(text, " 　\n\t")
+	if i < 0 {
+		return text, ""
+	}
+	return text[:i], strings.TrimSpace(text[i:])
+}
+
+// PostbackData はボタンを押したときに command を実行させる data を作る
+func PostbackData(command, args string) string {
+	v := url.Values{"cmd": {command}}
+	if args != "" {
+		v.Set("args", args)
+	}
+	return v.Encode()
+}
+
+func parsePostback(data string) (command, args string) {
+	v, err := url.ParseQuery(data)
+	if err != nil {
+		return "", ""
+	}
+	return v.Get("cmd"), v.Get("args")
+}
+
+/* ---------- モード ---------- */
+
+// ユーザーごとの現在のモード
+type ModeStore interface {
+	Mode(userID string) string
+	SetMode(userID, mode string)
+}
+
+type memoryModes struct {
+	mu    sync.Mutex
+	modes map[string]string
+}
+
+// プロセス内に保持するモード（再起動で消える）
+func NewMemoryModes() ModeStore {
+	return &memoryModes{modes: map[string]string{}}
+}
+
+func (m *memoryModes) Mode(userID string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.modes[userID]
+}
+
+func (m *memoryModes) SetMode(userID, mode string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if mode == "" {
+		delete(m.modes, userID)
+		return
+	}
+	m.modes[userID] = mode
+}
+
+/* ---------- ルーター ---------- */
+
+type modeHandlers struct {
+	text Handler
+	file Handler
+}
+
+type Router struct {
+	modes      ModeStore
+	middleware []Middleware
+
+	follow   Handler
+	commands map[string]Handler
+	byMode   map[string]modeHandlers
+	noMode   modeHandlers
+}
+
+func New(modes ModeStore) *Router {
+	return &Router{
+		modes:    modes,
+		commands: map[string]Handler{},
+		byMode:   map[string]modeHandlers{},
+	}
+}
+
+// すべてのハンドラに適用するミドルウェア（登録順に外側）
+func (rt *Router) Use(mws ...Middleware) {
+	rt.middleware = append(rt.middleware, mws...)
+}
+
+func (rt *Router) Follow(h Handler) {
+	rt.follow = h
+}
+
+// # コマンドを別名とともに登録する
+func (rt *Router) Command(name string, h Handler, aliases ...string) {
+	rt.commands[name] = h
+	for _, a := range aliases {
+		rt.commands[a] = h
+	}
+}
+
+// モード中のテキスト・ファイル（画像を含む）の処理（nil なら未対応）
+func (rt *Router) Mode(mode string, text, file Handler) {
+	rt.byMode[mode] = modeHandlers{text: text, file: file}
+}
+
+// モード未選択時の処理
+func (rt *Router) NoMode(text, file Handler) {
+	rt.noMode = modeHandlers{text: text, file: file}
+}
+
+// コマンドとして登録されているか
+func (rt *Router) IsCommand(command string) bool {
+	_, ok := rt.commands[command]
+	return ok
+}
+
+func (rt *Router) route(req *Request) Handler {
+	switch req.Kind {
+	case KindFollow:
+		return rt.follow
+	case KindText:
+		if h, ok := rt.commands[req.Command]; ok {
+			return h
+		}
+	case KindPostback:
+		// 未登録のボタン（古いメニュー等）は何もしない
+		return rt.commands[req.Command]
+	}
+
+	mh, ok := rt.byMode[req.Mode]
+	if !ok {
+		mh = rt.noMode
+	}
+	switch req.Kind {
+	case KindText:
+		return mh.text
+	case KindFile, KindImage:
+		return mh.file
+	}
+	return nil
+}
+
+// 1件のイベントを処理する
+func (rt *Router) Dispatch(ctx context.Context, req *Request) {
+	if req.Modes == nil {
+		req.Modes = rt.modes
+	}
+	req.Mode = rt.modes.Mode(req.UserID)
+	switch req.Kind {
+	case KindText:
+		req.Command, req.Args = ParseCommand(req.Text)
+	case KindPostback:
+		req.Command, req.Args = parsePostback(req.Data)
+	}
+
+	h := rt.route(req)
+	if h == nil {
+		h = HandlerFunc(func(context.Context, *Request) {})
+	}
+	Chain(h, rt.middleware...).Handle(ctx, req)
+}
@@ -0,0 +1,208 @@
+package router
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+// 返信を記録する Replier
+type fakeReplier struct {
+	texts []string
+}
+
+func (f *fakeReplier) Reply(_ context.Context, messages ...linebot.SendingMessage) error {
+	for _, m := range messages {
+		if t, ok := m.(*linebot.TextMessage); ok {
+			f.texts = append(f.texts, t.Text)
+		}
+	}
+	return nil
+}
+
+func (f *fakeReplier) Content(context.Context, string) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
+// 呼ばれたハンドラの名前と受け取った Request を記録する
+type recorder struct {
+	name string
+	req  *Request
+}
+
+func (r *recorder) handler(name string) Handler {
+	return HandlerFunc(func(_ context.Context, req *Request) {
+		r.name, r.req = name, req
+	})
+}
+
+func newTestRouter(rec *recorder) *Router {
+	rt := New(NewMemoryModes())
+	rt.Follow(rec.handler("follow"))
+	rt.Command("#生成", rec.handler("#生成"), "#generate")
+	rt.Command("#再生成", rec.handler("#再生成"))
+	rt.NoMode(rec.handler("noMode.text"), rec.handler("noMode.file"))
+	rt.Mode("chat", rec.handler("chat.text"), nil)
+	rt.Mode("generate", rec.handler("generate.text"), rec.handler("generate.file"))
+	return rt
+}
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		text, command, args string
+	}{
+		{"#生成", "#生成", ""},
+		{"#修正 考察をもっと詳しく", "#修正", "考察をもっと詳しく"},
+		{"#修正　全角空白", "#修正", "全角空白"},
+		{"#修正\n改行", "#修正", "改行"},
+		{"こんにちは #生成", "", ""},
+	}
+	for _, tt := range tests {
+		command, args := ParseCommand(tt.text)
+		if command != tt.command || args != tt.args {
+			t.Errorf("ParseCommand(%q) = (%q, %q), want (%q, %q)", tt.text, command, args, tt.command, tt.args)
+		}
+	}
+}
+
+func TestDispatchCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     Request
+		mode    string
+		handler string // 空なら何も呼ばれない
+		args    string
+	}{
+		{"コマンド", Request{Kind: KindText, Text: "#生成"}, "", "#生成", ""},
+		{"別名と引数", Request{Kind: KindText, Text: "#generate 追加の指示"}, "chat", "#生成", "追加の指示"},
+		{"コマンドはモードより優先", Request{Kind: KindText, Text: "#生成"}, "generate", "#生成", ""},
+		{"未登録のコマンドはモードのテキスト", Request{Kind: KindText, Text: "#不明"}, "chat", "chat.text", ""},
+		{"ポストバック", Request{Kind: KindPostback, Data: PostbackData("#再生成", "job-1")}, "generate", "#再生成", "job-1"},
+		{"未登録のポストバックは何もしない", Request{Kind: KindPostback, Data: PostbackData("#不明", "")}, "chat", "", ""},
+		{"友だち追加", Request{Kind: KindFollow}, "generate", "follow", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &recorder{}
+			rt := newTestRouter(rec)
+			rt.modes.SetMode("U1", tt.mode)
+
+			req := tt.req
+			req.UserID = "U1"
+			req.Replier = &fakeReplier{}
+			rt.Dispatch(context.Background(), &req)
+
+			if rec.name != tt.handler {
+				t.Fatalf("呼ばれたハンドラ = %q, want %q", rec.name, tt.handler)
+			}
+			if rec.req != nil && rec.req.Args != tt.args {
+				t.Errorf("Args = %q, want %q", rec.req.Args, tt.args)
+			}
+		})
+	}
+}
+
+func TestDispatchModeFallback(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		kind    string
+		handler string
+	}{
+		{"モード未選択のテキスト", "", KindText, "noMode.text"},
+		{"モード未選択のファイル", "", KindFile, "noMode.file"},
+		{"未登録のモードは未選択と同じ", "removed", KindText, "noMode.text"},
+		{"モードのテキスト", "generate", KindText, "generate.text"},
+		{"モードのファイル", "generate", KindFile, "generate.file"},
+		{"画像はファイルと同じ", "generate", KindImage, "generate.file"},
+		{"未対応（nil）は何もしない", "chat", KindFile, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &recorder{}
+			rt := newTestRouter(rec)
+			rt.modes.SetMode("U1", tt.mode)
+
+			req := &Request{Kind: tt.kind, UserID: "U1", Text: "本文", Replier: &fakeReplier{}}
+			rt.Dispatch(context.Background(), req)
+
+			if rec.name != tt.handler {
+				t.Fatalf("呼ばれたハンドラ = %q, want %q", rec.name, tt.handler)
+			}
+			if rec.req != nil && rec.req.Mode != tt.mode {
+				t.Errorf("Mode = %q, want %q", rec.req.Mode, tt.mode)
+			}
+		})
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	var calls []string
+	mw := func(name string, stop bool) Middleware {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(ctx context.Context, req *Request) {
+				calls = append(calls, name)
+				if stop {
+					req.ReplyText(ctx, name+" で止めました")
+					return
+				}
+				next.Handle(ctx, req)
+			})
+		}
+	}
+	handler := HandlerFunc(func(context.Context, *Request) { calls = append(calls, "handler") })
+
+	tests := []struct {
+		name  string
+		mws   [][]Middleware // Use の呼び出しごと
+		req   Request
+		calls []string
+		reply string
+	}{
+		{
+			name:  "登録順に外側",
+			mws:   [][]Middleware{{mw("log", false), mw("rate", false)}, {mw("auth", false)}},
+			req:   Request{Kind: KindText, Text: "#生成"},
+			calls: []string{"log", "rate", "auth", "handler"},
+		},
+		{
+			name:  "途中で止めれば内側は呼ばれない",
+			mws:   [][]Middleware{{mw("log", false), mw("rate", true), mw("auth", false)}},
+			req:   Request{Kind: KindText, Text: "#生成"},
+			calls: []string{"log", "rate"},
+			reply: "rate で止めました",
+		},
+		{
+			name:  "ハンドラがなくてもミドルウェアは通る",
+			mws:   [][]Middleware{{mw("log", false), mw("auth", false)}},
+			req:   Request{Kind: KindPostback, Data: PostbackData("#不明", "")},
+			calls: []string{"log", "auth"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = nil
+			rt := New(NewMemoryModes())
+			rt.Command("#生成", handler)
+			for _, mws := range tt.mws {
+				rt.Use(mws...)
+			}
+
+			replier := &fakeReplier{}
+			req := tt.req
+			req.UserID = "U1"
+			req.Replier = replier
+			rt.Dispatch(context.Background(), &req)
+
+			if strings.Join(calls, ",") != strings.Join(tt.calls, ",") {
+				t.Errorf("呼び出し順 = %v, want %v", calls, tt.calls)
+			}
+			if tt.reply != "" && (len(replier.texts) != 1 || replier.texts[0] != tt.reply) {
+				t.Errorf("返信 = %q, want %q", replier.texts, tt.reply)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package supabase
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"time"
+)
+
+// 認証コード利用の結果
+type RedeemResult string
+
+const (
+	RedeemSuccess     RedeemResult = "success"
+	RedeemInvalid     RedeemResult = "invalid"
+	RedeemAlreadyUsed RedeemResult = "already_used"
+	RedeemExpired     RedeemResult = "expired"
+	RedeemRevoked     RedeemResult = "revoked" // ユーザーが利用停止中（コードは消費しない）
+)
+
+// コードを使用済みにし（利用者と日時を記録）、ユーザーを作成する。
+// 既存ユーザーの場合はプランと利用期限を更新する。
+// 両方を redeem_auth_code 関数内の1トランザクションで行うので、
+// ユーザー作成に失敗してもコードは消費されない。
+func RedeemAuthCode(ctx context.Context, code, lineUserID string) (RedeemResult, error) {
+	var result RedeemResult
+	err := RPC(ctx, "redeem_auth_code",
+		map[string]string{
+			"p_code":         code,
+			"p_line_user_id": lineUserID,
+		},
+		&result,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	switch result {
+	case RedeemSuccess:
+		notifyUserChange(lineUserID)
+		return result, nil
+	case RedeemInvalid, RedeemAlreadyUsed, RedeemExpired, RedeemRevoked:
+		return result, nil
+	}
+	return "", fmt.Errorf("redeem_auth_code の戻り値が不正です: %q", result)
+}
+
+/* =======================
+   コードの発行・管理
+======================= */
+
+type AuthCode struct {
+	Code      string     `json:"code"`
+	Used      bool       `json:"used"`
+	UsedBy    *string    `json:"used_by,omitempty"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Batch     string     `json:"batch,omitempty"`
+	Plan      string     `json:"plan,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// 発行時に送る行（created_at などは DB の既定値に任せる）
+type newAuthCode struct {
+	Code      string     `json:"code"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Batch     string     `json:"batch,omitempty"`
+	Plan      string     `json:"plan"`
+}
+
+const authCodeColumns = "code,used,used_by,used_at,expires_at,batch,plan,revoked_at,created_at"
+
+// 紛らわしい文字（0/O, 1/I/L）を除いた英大文字と数字
+const codeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+const DefaultCodeLength = 10
+
+type IssueOptions struct {
+	Count     int
+	Length    int // 0 なら DefaultCodeLength
+	Batch     string
+	Plan      string // 空なら PlanStandard
+	ExpiresAt *time.Time
+}
+
+// crypto/rand でコードを生成して登録する
+func IssueAuthCodes(ctx context.Context, opts IssueOptions) ([]AuthCode, error) {
+	if opts.Count <= 0 {
+		return nil, InputError("発行数は1以上を指定してください")
+	}
+	if opts.Length == 0 {
+		opts.Length = DefaultCodeLength
+	}
+	if opts.Length < 8 {
+		return nil, InputError("コードは8文字以上にしてください")
+	}
+	if opts.Plan == "" {
+		opts.Plan = PlanStandard
+	}
+	if !IsValidPlan(opts.Plan) {
+		return nil, InputError("不明なプランです: " + opts.Plan)
+	}
+
+	seen := map[string]bool{}
+	codes := make([]newAuthCode, 0, opts.Count)
+	for len(codes) < opts.Count {
+		code, err := GenerateCode(opts.Length)
+		if err != nil {
+			return nil, err
+		}
+		if seen[code] {
+			continue
+		}
+		seen[code] = true
+		codes = append(codes, newAuthCode{
+			Code:      code,
+			Batch:     opts.Batch,
+			Plan:      opts.Plan,
+			ExpiresAt: opts.ExpiresAt,
+		})
+	}
+
+	var created []AuthCode
+	if err := From("auth_codes").Select(authCodeColumns).Insert(ctx, codes, &created); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func GenerateCode(length int) (string, error) {
+	// 剰余の偏りが出ないよう、文字数の倍数に収まらないバイトは捨てる
+	limit := 256 - 256%len(codeAlphabet)
+	code := make([]byte, 0, length)
+	buf := make([]byte, length*2)
+	for len(code) < length {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) >= limit {
+				continue
+			}
+			code = append(code, codeAlphabet[int(b)%len(codeAlphabet)])
+			if len(code) == length {
+				break
+			}
+		}
+	}
+	return string(code), nil
+}
+
+// 一覧の状態指定
+const (
+	CodeStatusAll     = ""
+	CodeStatusUnused  = "unused"
+	CodeStatusUsed    = "used"
+	CodeStatusRevoked = "revoked"
+)
+
+type AuthCodeFilter struct {
+	Batch  string
+	Status string
+	Limit  int
+}
+
+func ListAuthCodes(ctx context.Context, f AuthCodeFilter) ([]AuthCode, error) {
+	q := From("auth_codes").
+		Select(authCodeColumns).
+		Order("created_at", false)
+	if f.Batch != "" {
+		q.Eq("batch", f.Batch)
+	}
+	switch f.Status {
+	case CodeStatusAll:
+	case CodeStatusUnused:
+		q.Eq("used", "false").Is("revoked_at", "null")
+	case CodeStatusUsed:
+		q.Eq("used", "true")
+	case CodeStatusRevoked:
+		q.Not("revoked_at", "is", "null")
+	default:
+		return nil, InputError("不明な状態です: " + f.Status)
+	}
+	if f.Limit > 0 {
+		q.Limit(f.Limit)
+	}
+
+	var codes []AuthCode
+	if err := q.Get(ctx, &codes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// 未使用のコードを失効させる。batch と codes のどちらか（または両方）で絞り込む。
+func RevokeAuthCodes(ctx context.Context, batch string, codes []string) ([]AuthCode, error) {
+	if batch == "" && len(codes) == 0 {
+		return nil, InputError("失効させるバッチかコードを指定してください")
+	}
+
+	q := From("auth_codes").
+		Select(authCodeColumns).
+		Eq("used", "false").
+		Is("revoked_at", "null")
+	if batch != "" {
+		q.Eq("batch", batch)
+	}
+	if len(codes) > 0 {
+		q.In("code", codes...)
+	}
+
+	var revoked []AuthCode
+	err := q.Update(ctx, map[string]any{"revoked_at": time.Now().UTC()}, &revoked)
+	if err != nil {
+		return nil, err
+	}
+	return revoked, nil
+}
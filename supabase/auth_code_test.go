@@ -0,0 +1,62 @@
+package supabase
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go_project/config"
+)
+
+// 発行時の行に created_at を含めない（含めると DB の default now() が効かない）
+func TestIssueAuthCodesPayload(t *testing.T) {
+	var rows []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rows = nil
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &rows); err != nil {
+			t.Errorf("送信した行を読めません: %v\n%s", err, body)
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write(body)
+	}))
+	defer srv.Close()
+	Configure(config.Supabase{URL: srv.URL, ServiceRoleKey: "test"})
+	defer Configure(config.Supabase{})
+
+	expires := time.Date(2026, 12, 31, 15, 0, 0, 0, time.UTC)
+	codes, err := IssueAuthCodes(context.Background(), IssueOptions{Count: 2, Batch: "2026-10", Plan: PlanPro, ExpiresAt: &expires})
+	if err != nil {
+		t.Fatalf("IssueAuthCodes: %v", err)
+	}
+	if len(rows) != 2 || len(codes) != 2 {
+		t.Fatalf("送信 %d 行、戻り値 %d 件, want 2", len(rows), len(codes))
+	}
+	for _, row := range rows {
+		for _, col := range []string{"created_at", "used", "used_by", "used_at", "revoked_at"} {
+			if _, ok := row[col]; ok {
+				t.Errorf("%s を送信しています: %v", col, row)
+			}
+		}
+		if row["batch"] != "2026-10" || row["plan"] != PlanPro || row["expires_at"] != "2026-12-31T15:00:00Z" {
+			t.Errorf("行 = %v", row)
+		}
+	}
+
+	// バッチ・期限がなければ送らない
+	if _, err := IssueAuthCodes(context.Background(), IssueOptions{Count: 1}); err != nil {
+		t.Fatalf("IssueAuthCodes: %v", err)
+	}
+	for _, col := range []string{"batch", "expires_at", "created_at"} {
+		if _, ok := rows[0][col]; ok {
+			t.Errorf("%s を送信しています: %v", col, rows[0])
+		}
+	}
+	if rows[0]["plan"] != PlanStandard {
+		t.Errorf("plan = %v, want %s", rows[0]["plan"], PlanStandard)
+	}
+}
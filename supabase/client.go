@@ -0,0 +1,237 @@
+package supabase
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go_project/config"
+	"go_project/logging"
+	"go_project/metrics"
+	"go_project/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	cfg    config.Supabase
+	envErr = errors.New("SUPABASE_URL,SUPABASE_SERVICE_ROLE_KEYが見つかりません")
+)
+
+func Configure(c config.Supabase) {
+	cfg = c
+}
+
+// 接続を使い回すための共有クライアント
+var httpClient = &http.Client{
+	Timeout: 15 * time.Second,
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          50,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+	},
+}
+
+/* =======================
+   エラー
+======================= */
+
+// PostgREST が返すエラー本文
+type APIError struct {
+	StatusCode int    `json:"-"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	Details    string `json:"details"`
+	Hint       string `json:"hint"`
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("supabase: status %d", e.StatusCode)
+	if e.Code != "" {
+		msg += " code=" + e.Code
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Details != "" {
+		msg += " (" + e.Details + ")"
+	}
+	return msg
+}
+
+// 呼び出し側の指定が不正なときのエラー（APIの 400 に相当）
+type InputError string
+
+func (e InputError) Error() string { return string(e) }
+
+// 一意制約違反（既に登録済み等）
+func IsConflict(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.StatusCode == http.StatusConflict || apiErr.Code == "23505"
+}
+
+func decodeError(resp *http.Response) error {
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if len(b) > 0 && json.Unmarshal(b, apiErr) != nil {
+		apiErr.Message = string(b)
+	}
+	return apiErr
+}
+
+/* =======================
+   リクエスト
+======================= */
+
+// do は path にリクエストを送り、2xx 以外は *APIError を返す。
+// out が非nilのときは本文をデコードする（return=representation）。
+// prefer は Prefer ヘッダに追加する指定（resolution=merge-duplicates 等）。
+func do(ctx context.Context, method, path string, body, out any, prefer ...string) error {
+	if cfg.URL == "" || cfg.ServiceRoleKey == "" {
+		return envErr
+	}
+
+	var reader io.Reader
+	if body != nil {
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return err
+		}
+		reader = &buf
+	}
+
+	// path.Join は使わず、文字列連結で安全に
+	req, err := http.NewRequestWithContext(ctx, method, cfg.URL+path, reader)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("apikey", cfg.ServiceRoleKey.Value())
+	req.Header.Set("Authorization", "Bearer "+cfg.ServiceRoleKey.Value())
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if out != nil {
+		prefer = append(prefer, "return=representation")
+	} else {
+		prefer = append(prefer, "return=minimal")
+	}
+	req.Header.Set("Prefer", strings.Join(prefer, ","))
+
+	resp, err := send(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return decodeError(resp)
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		// keep-alive のため本文を読み切る
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// 送信して結果をログに残す（クエリにはユーザーID等が入るのでパスのみ）
+func send(ctx context.Context, req *http.Request) (resp *http.Response, err error) {
+	ctx, span := tracing.Start(ctx, "supabase "+req.Method+" "+req.URL.Path,
+		attribute.String("http.request.method", req.Method),
+		attribute.String("url.path", req.URL.Path),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	req = req.WithContext(ctx)
+	tracing.Inject(ctx, req.Header)
+	if id := logging.CorrelationID(ctx); id != "" {
+		req.Header.Set("X-Request-ID", id)
+	}
+
+	start := time.Now()
+	resp, err = httpClient.Do(req)
+	attrs := []any{"method", req.Method, "path", req.URL.Path, "duration_ms", time.Since(start).Milliseconds()}
+	if err != nil {
+		metrics.Supabase(req.Method, req.URL.Path, 0, start)
+		slog.ErrorContext(ctx, "supabase 通信失敗", append(attrs, logging.Err(err))...)
+		return nil, err
+	}
+	metrics.Supabase(req.Method, req.URL.Path, resp.StatusCode, start)
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+
+	level := slog.LevelDebug
+	if resp.StatusCode >= 500 {
+		level = slog.LevelWarn
+	}
+	slog.Log(ctx, level, "supabase", append(attrs, "status", resp.StatusCode)...)
+	return resp, nil
+}
+
+// count は条件に一致する行数を返す（本文は取得しない）
+func count(ctx context.Context, path string) (int, error) {
+	if cfg.URL == "" || cfg.ServiceRoleKey == "" {
+		return 0, envErr
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, cfg.URL+path, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("apikey", cfg.ServiceRoleKey.Value())
+	req.Header.Set("Authorization", "Bearer "+cfg.ServiceRoleKey.Value())
+	req.Header.Set("Prefer", "count=exact")
+
+	resp, err := send(ctx, req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return 0, &APIError{StatusCode: resp.StatusCode, Message: resp.Status}
+	}
+
+	// Content-Range: 0-24/3573 または */0
+	cr := resp.Header.Get("Content-Range")
+	i := strings.LastIndex(cr, "/")
+	if i < 0 {
+		return 0, fmt.Errorf("Content-Range が不正です: %q", cr)
+	}
+	n, err := strconv.Atoi(cr[i+1:])
+	if err != nil {
+		return 0, fmt.Errorf("Content-Range が不正です: %q", cr)
+	}
+	return n, nil
+}
+
+// Ping は PostgREST に到達でき、キーが有効かを確かめる（小さな plans 表を数える）
+func Ping(ctx context.Context) error {
+	_, err := count(ctx, "/rest/v1/plans?select=name&limit=1")
+	return err
+}
+
+// RPC は Postgres 関数 /rest/v1/rpc/<fn> を呼ぶ
+func RPC(ctx context.Context, fn string, args, out any) error {
+	return do(ctx, http.MethodPost, "/rest/v1/rpc/"+fn, args, out)
+}
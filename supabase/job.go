@@ -0,0 +1,96 @@
+package supabase
+
+import (
+	"context"
+	"time"
+)
+
+// 生成ジョブの状態
+const (
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+type Job struct {
+	ID           string     `json:"id"`
+	LineUserID   string     `json:"line_user_id"`
+	Status       string     `json:"status"`
+	Error        *string    `json:"error,omitempty"`
+	Container    *string    `json:"container,omitempty"`
+	BlobName     *string    `json:"blob_name,omitempty"`
+	TemplateHash *string    `json:"template_hash,omitempty"`
+	Model        *string    `json:"model,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+}
+
+const jobColumns = "id,line_user_id,status,error,container,blob_name,template_hash,model,created_at,finished_at"
+
+func CreateJob(ctx context.Context, id, lineUserID, templateHash, model string) error {
+	return From("generation_jobs").Insert(ctx,
+		map[string]string{
+			"id":            id,
+			"line_user_id":  lineUserID,
+			"status":        JobRunning,
+			"template_hash": templateHash,
+			"model":         model,
+		},
+		nil,
+	)
+}
+
+func FinishJob(ctx context.Context, id, container, blobName string) error {
+	return From("generation_jobs").Eq("id", id).Update(ctx,
+		map[string]any{
+			"status":      JobSucceeded,
+			"container":   container,
+			"blob_name":   blobName,
+			"finished_at": time.Now().UTC(),
+		},
+		nil,
+	)
+}
+
+func FailJob(ctx context.Context, id string, cause error) error {
+	return From("generation_jobs").Eq("id", id).Update(ctx,
+		map[string]any{
+			"status":      JobFailed,
+			"error":       cause.Error(),
+			"finished_at": time.Now().UTC(),
+		},
+		nil,
+	)
+}
+
+type JobFilter struct {
+	LineUserID string
+	Status     string
+	Limit      int
+	Offset     int
+}
+
+// 新しい順
+func ListJobs(ctx context.Context, f JobFilter) ([]Job, error) {
+	q := From("generation_jobs").
+		Select(jobColumns).
+		Order("created_at", false)
+	if f.LineUserID != "" {
+		q.Eq("line_user_id", f.LineUserID)
+	}
+	if f.Status != "" {
+		q.Eq("status", f.Status)
+	}
+	if f.Limit > 0 {
+		q.Limit(f.Limit)
+	}
+	if f.Offset > 0 {
+		q.Offset(f.Offset)
+	}
+
+	var jobs []Job
+	if err := q.Get(ctx, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
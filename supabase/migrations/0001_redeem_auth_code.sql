@@ -0,0 +1,47 @@
+-- 認証コードの利用を1トランザクションで行う
+-- （コードの使用済み化とユーザー作成のどちらかが失敗すれば両方取り消される）
+
+alter table auth_codes
+  add column if not exists used_by    text,
+  add column if not exists used_at    timestamptz,
+  add column if not exists expires_at timestamptz;
+
+create unique index if not exists users_line_user_id_key on users (line_user_id);
+
+create or replace function redeem_auth_code(p_code text, p_line_user_id text)
+returns text
+language plpgsql
+security definer
+set search_path = public
+as $$
+declare
+  v_code auth_codes%rowtype;
+begin
+  -- 同じコードの同時利用を防ぐため行ロックを取る
+  select * into v_code from auth_codes where code = p_code for update;
+
+  if not found then
+    return 'invalid';
+  end if;
+  if v_code.used then
+    return 'already_used';
+  end if;
+  if v_code.expires_at is not null and v_code.expires_at <= now() then
+    return 'expired';
+  end if;
+
+  update auth_codes
+     set used = true,
+         used_by = p_line_user_id,
+         used_at = now()
+   where code = p_code;
+
+  insert into users (line_user_id)
+  values (p_line_user_id)
+  on conflict (line_user_id) do nothing;
+
+  return 'success';
+end;
+$$;
+
+revoke all on function redeem_auth_code(text, text) from public, anon, authenticated;
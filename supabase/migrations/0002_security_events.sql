@@ -0,0 +1,12 @@
+-- 認証コード総当たり等の不審な操作の記録（管理者確認用）
+
+create table if not exists security_events (
+  id           bigint generated always as identity primary key,
+  line_user_id text        not null,
+  kind         text        not null,
+  detail       jsonb       not null default '{}'::jsonb,
+  created_at   timestamptz not null default now()
+);
+
+create index if not exists security_events_created_at_idx on security_events (created_at desc);
+create index if not exists security_events_line_user_id_idx on security_events (line_user_id);
@@ -0,0 +1,46 @@
+-- 認証コードのバッチ名・プラン・失効
+
+alter table auth_codes
+  add column if not exists batch      text,
+  add column if not exists plan       text        not null default 'standard',
+  add column if not exists revoked_at timestamptz,
+  add column if not exists created_at timestamptz not null default now();
+
+create unique index if not exists auth_codes_code_key on auth_codes (code);
+create index if not exists auth_codes_batch_idx on auth_codes (batch);
+
+-- 失効したコードは存在しないものとして扱う
+create or replace function redeem_auth_code(p_code text, p_line_user_id text)
+returns text
+language plpgsql
+security definer
+set search_path = public
+as $$
+declare
+  v_code auth_codes%rowtype;
+begin
+  select * into v_code from auth_codes where code = p_code for update;
+
+  if not found or v_code.revoked_at is not null then
+    return 'invalid';
+  end if;
+  if v_code.used then
+    return 'already_used';
+  end if;
+  if v_code.expires_at is not null and v_code.expires_at <= now() then
+    return 'expired';
+  end if;
+
+  update auth_codes
+     set used = true,
+         used_by = p_line_user_id,
+         used_at = now()
+   where code = p_code;
+
+  insert into users (line_user_id)
+  values (p_line_user_id)
+  on conflict (line_user_id) do nothing;
+
+  return 'success';
+end;
+$$;
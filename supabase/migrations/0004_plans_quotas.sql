@@ -0,0 +1,153 @@
+-- 料金プランと月間利用枠
+-- 利用回数は日本時間の日ごとに集計し、期間の開始日（リセット日）はアプリ側から渡す
+
+create table if not exists plans (
+  name                text primary key,
+  monthly_generations integer, -- null は無制限
+  monthly_chats       integer  -- null は無制限
+);
+
+insert into plans (name, monthly_generations, monthly_chats) values
+  ('free',       3,   100),
+  ('standard',  30,  1000),
+  ('pro',      200, 10000)
+on conflict (name) do nothing;
+
+-- 既存の購入者は standard 扱い
+alter table users
+  add column if not exists plan text not null default 'standard' references plans (name);
+
+create table if not exists usage_daily (
+  line_user_id text    not null,
+  day          date    not null,
+  generations  integer not null default 0,
+  chats        integer not null default 0,
+  primary key (line_user_id, day)
+);
+
+-- 利用したコードのプランをユーザーに設定する
+create or replace function redeem_auth_code(p_code text, p_line_user_id text)
+returns text
+language plpgsql
+security definer
+set search_path = public
+as $$
+declare
+  v_code auth_codes%rowtype;
+begin
+  select * into v_code from auth_codes where code = p_code for update;
+
+  if not found or v_code.revoked_at is not null then
+    return 'invalid';
+  end if;
+  if v_code.used then
+    return 'already_used';
+  end if;
+  if v_code.expires_at is not null and v_code.expires_at <= now() then
+    return 'expired';
+  end if;
+
+  update auth_codes
+     set used = true,
+         used_by = p_line_user_id,
+         used_at = now()
+   where code = p_code;
+
+  insert into users (line_user_id, plan)
+  values (p_line_user_id, v_code.plan)
+  on conflict (line_user_id) do update set plan = excluded.plan;
+
+  return 'success';
+end;
+$$;
+
+-- 枠内なら1回分を記録する。p_kind は 'generation' か 'chat'。
+create or replace function consume_quota(p_line_user_id text, p_kind text, p_period_start date)
+returns table (allowed boolean, used integer, quota integer)
+language plpgsql
+security definer
+set search_path = public
+as $$
+declare
+  v_limit integer;
+  v_used  integer;
+begin
+  if p_kind not in ('generation', 'chat') then
+    raise exception 'unknown quota kind: %', p_kind;
+  end if;
+
+  select case p_kind when 'generation' then p.monthly_generations else p.monthly_chats end
+    into v_limit
+    from users u
+    join plans p on p.name = u.plan
+   where u.line_user_id = p_line_user_id;
+  if not found then
+    raise exception 'user not found' using errcode = 'P0002';
+  end if;
+
+  -- 同じユーザーの同時リクエストで上限を超えないよう直列化する
+  perform pg_advisory_xact_lock(hashtext('quota:' || p_line_user_id));
+
+  select coalesce(sum(case p_kind when 'generation' then d.generations else d.chats end), 0)
+    into v_used
+    from usage_daily d
+   where d.line_user_id = p_line_user_id
+     and d.day >= p_period_start;
+
+  if v_limit is not null and v_used >= v_limit then
+    return query select false, v_used, v_limit;
+    return;
+  end if;
+
+  insert into usage_daily (line_user_id, day, generations, chats)
+  values (
+    p_line_user_id,
+    (now() at time zone 'Asia/Tokyo')::date,
+    case p_kind when 'generation' then 1 else 0 end,
+    case p_kind when 'chat' then 1 else 0 end
+  )
+  on conflict (line_user_id, day) do update
+    set generations = usage_daily.generations + excluded.generations,
+        chats       = usage_daily.chats + excluded.chats;
+
+  return query select true, v_used + 1, v_limit;
+end;
+$$;
+
+-- AI呼び出しが失敗したときに1回分を戻す
+create or replace function release_quota(p_line_user_id text, p_kind text)
+returns void
+language sql
+security definer
+set search_path = public
+as $$
+  update usage_daily
+     set generations = greatest(generations - case p_kind when 'generation' then 1 else 0 end, 0),
+         chats       = greatest(chats - case p_kind when 'chat' then 1 else 0 end, 0)
+   where line_user_id = p_line_user_id
+     and day = (now() at time zone 'Asia/Tokyo')::date;
+$$;
+
+-- 期間内の利用状況
+create or replace function get_quota(p_line_user_id text, p_period_start date)
+returns table (plan text, generations_used integer, generations_quota integer, chats_used integer, chats_quota integer)
+language sql
+stable
+security definer
+set search_path = public
+as $$
+  select u.plan,
+         coalesce((select sum(d.generations) from usage_daily d
+                    where d.line_user_id = u.line_user_id and d.day >= p_period_start), 0)::integer,
+         p.monthly_generations,
+         coalesce((select sum(d.chats) from usage_daily d
+                    where d.line_user_id = u.line_user_id and d.day >= p_period_start), 0)::integer,
+         p.monthly_chats
+    from users u
+    join plans p on p.name = u.plan
+   where u.line_user_id = p_line_user_id;
+$$;
+
+revoke all on function consume_quota(text, text, date) from public, anon, authenticated;
+revoke all on function release_quota(text, text) from public, anon, authenticated;
+revoke all on function get_quota(text, date) from public, anon, authenticated;
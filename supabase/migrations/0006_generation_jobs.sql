@@ -0,0 +1,20 @@
+-- 文書生成ジョブの履歴（管理画面・API用）
+
+create table if not exists generation_jobs (
+  id            text primary key,
+  line_user_id  text        not null,
+  status        text        not null default 'running', -- running / succeeded / failed
+  error         text,
+  container     text,
+  blob_name     text,
+  template_hash text,
+  model         text,
+  created_at    timestamptz not null default now(),
+  finished_at   timestamptz
+);
+
+create index if not exists generation_jobs_created_at_idx on generation_jobs (created_at desc);
+create index if not exists generation_jobs_line_user_id_idx on generation_jobs (line_user_id, created_at desc);
+
+alter table users
+  add column if not exists created_at timestamptz not null default now();
@@ -0,0 +1,4 @@
+-- 管理画面のログイン失敗など、LINE ユーザーに紐付かない不審な操作も記録する
+
+alter table security_events
+  alter column line_user_id drop not null;
@@ -0,0 +1,55 @@
+-- 無期限（expires_at が null）の既存ユーザーがコードを使っても期限を付けない。
+-- 0005 では null を「今」とみなしていたため、無期限の購入者に期限が付いていた。
+create or replace function redeem_auth_code(p_code text, p_line_user_id text)
+returns text
+language plpgsql
+security definer
+set search_path = public
+as $$
+declare
+  v_code      auth_codes%rowtype;
+  v_user      users%rowtype;
+  v_existing  boolean;
+  v_duration  integer;
+  v_expires   timestamptz;
+begin
+  select * into v_user from users where line_user_id = p_line_user_id for update;
+  v_existing := found;
+  if v_existing and v_user.revoked_at is not null then
+    return 'revoked';
+  end if;
+
+  select * into v_code from auth_codes where code = p_code for update;
+
+  if not found or v_code.revoked_at is not null then
+    return 'invalid';
+  end if;
+  if v_code.used then
+    return 'already_used';
+  end if;
+  if v_code.expires_at is not null and v_code.expires_at <= now() then
+    return 'expired';
+  end if;
+
+  select duration_days into v_duration from plans where name = v_code.plan;
+  if v_existing and v_user.expires_at is null then
+    v_expires := null; -- 無期限のまま
+  elsif v_duration is not null then
+    v_expires := greatest(coalesce(v_user.expires_at, now()), now()) + make_interval(days => v_duration);
+  end if;
+
+  update auth_codes
+     set used = true,
+         used_by = p_line_user_id,
+         used_at = now()
+   where code = p_code;
+
+  insert into users (line_user_id, plan, expires_at)
+  values (p_line_user_id, v_code.plan, v_expires)
+  on conflict (line_user_id) do update
+    set plan = excluded.plan,
+        expires_at = excluded.expires_at;
+
+  return 'success';
+end;
+$$;
@@ -0,0 +1,71 @@
+-- 上位プランの有効なユーザーが下位プランのコードを使っても、プランは下げずに今のプランのまま期限だけ延ばす。
+-- 0008 までは常にコードのプランで上書きしていたため、pro のユーザーが free のコードで free に下がっていた。
+-- 期限切れのユーザーはコードのプランにする。
+
+alter table plans
+  add column if not exists rank integer not null default 0; -- 大きいほど上位
+
+update plans set rank = 1 where name = 'free';
+update plans set rank = 2 where name = 'standard';
+update plans set rank = 3 where name = 'pro';
+
+create or replace function redeem_auth_code(p_code text, p_line_user_id text)
+returns text
+language plpgsql
+security definer
+set search_path = public
+as $$
+declare
+  v_code      auth_codes%rowtype;
+  v_user      users%rowtype;
+  v_existing  boolean;
+  v_duration  integer;
+  v_expires   timestamptz;
+  v_plan      text;
+begin
+  select * into v_user from users where line_user_id = p_line_user_id for update;
+  v_existing := found;
+  if v_existing and v_user.revoked_at is not null then
+    return 'revoked';
+  end if;
+
+  select * into v_code from auth_codes where code = p_code for update;
+
+  if not found or v_code.revoked_at is not null then
+    return 'invalid';
+  end if;
+  if v_code.used then
+    return 'already_used';
+  end if;
+  if v_code.expires_at is not null and v_code.expires_at <= now() then
+    return 'expired';
+  end if;
+
+  select duration_days into v_duration from plans where name = v_code.plan;
+  if v_existing and v_user.expires_at is null then
+    v_expires := null; -- 無期限のまま
+  elsif v_duration is not null then
+    v_expires := greatest(coalesce(v_user.expires_at, now()), now()) + make_interval(days => v_duration);
+  end if;
+
+  v_plan := v_code.plan;
+  if v_existing and (v_user.expires_at is null or v_user.expires_at > now())
+     and (select rank from plans where name = v_user.plan) > (select rank from plans where name = v_code.plan) then
+    v_plan := v_user.plan; -- 上位プランのまま
+  end if;
+
+  update auth_codes
+     set used = true,
+         used_by = p_line_user_id,
+         used_at = now()
+   where code = p_code;
+
+  insert into users (line_user_id, plan, expires_at)
+  values (p_line_user_id, v_plan, v_expires)
+  on conflict (line_user_id) do update
+    set plan = excluded.plan,
+        expires_at = excluded.expires_at;
+
+  return 'success';
+end;
+$$;
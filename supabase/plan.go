@@ -0,0 +1,37 @@
+package supabase
+
+import "context"
+
+// 認証コード・ユーザーに紐づく料金プラン
+const (
+	PlanFree     = "free"
+	PlanStandard = "standard"
+	PlanPro      = "pro"
+)
+
+func IsValidPlan(plan string) bool {
+	switch plan {
+	case PlanFree, PlanStandard, PlanPro:
+		return true
+	}
+	return false
+}
+
+type Plan struct {
+	Name               string `json:"name"`
+	MonthlyGenerations *int   `json:"monthly_generations"` // nil は無制限
+	MonthlyChats       *int   `json:"monthly_chats"`
+	DurationDays       *int   `json:"duration_days"` // nil は無期限
+}
+
+func ListPlans(ctx context.Context) ([]Plan, error) {
+	var plans []Plan
+	err := From("plans").
+		Select("name,monthly_generations,monthly_chats,duration_days").
+		Order("name", true).
+		Get(ctx, &plans)
+	if err != nil {
+		return nil, err
+	}
+	return plans, nil
+}
@@ -0,0 +1,152 @@
+package supabase
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+/* =======================
+   PostgREST クエリビルダー
+======================= */
+
+// Query はテーブルへのフィルタ付きパスを組み立てる。
+// 値は url.Values でエスケープするので利用者の入力をそのまま渡してよい。
+type Query struct {
+	table  string
+	params url.Values
+}
+
+func From(table string) *Query {
+	return &Query{table: table, params: url.Values{}}
+}
+
+func (q *Query) Select(columns string) *Query {
+	q.params.Set("select", columns)
+	return q
+}
+
+func (q *Query) filter(column, op, value string) *Query {
+	q.params.Add(column, op+"."+value)
+	return q
+}
+
+func (q *Query) Eq(column, value string) *Query  { return q.filter(column, "eq", value) }
+func (q *Query) Neq(column, value string) *Query { return q.filter(column, "neq", value) }
+func (q *Query) Gt(column, value string) *Query  { return q.filter(column, "gt", value) }
+func (q *Query) Gte(column, value string) *Query { return q.filter(column, "gte", value) }
+func (q *Query) Lt(column, value string) *Query  { return q.filter(column, "lt", value) }
+func (q *Query) Lte(column, value string) *Query { return q.filter(column, "lte", value) }
+
+// Is は null / true / false との比較
+func (q *Query) Is(column, value string) *Query { return q.filter(column, "is", value) }
+
+// Not は条件の否定（例: Not("revoked_at", "is", "null")）
+func (q *Query) Not(column, op, value string) *Query { return q.filter(column, "not."+op, value) }
+
+// ILike は部分一致検索（* がワイルドカード。利用者の入力は likeEscape してから渡す）
+func (q *Query) ILike(column, pattern string) *Query { return q.filter(column, "ilike", pattern) }
+
+// likeEscape は LIKE の % _ \ を文字そのものとして扱わせる。
+// PostgREST は * を % に置き換えるだけで * 自体を表す書き方がないため、* はワイルドカードのまま残る。
+func likeEscape(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// In はリスト内の値と一致するもの。要素は予約文字を含みうるので引用符で囲む。
+func (q *Query) In(column string, values ...string) *Query {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = quote(v)
+	}
+	return q.filter(column, "in", "("+strings.Join(quoted, ",")+")")
+}
+
+// Or はいずれかの条件に一致するもの（条件は Cond で作る）
+func (q *Query) Or(conditions ...string) *Query {
+	q.params.Add("or", "("+strings.Join(conditions, ",")+")")
+	return q
+}
+
+// Cond は Or に渡す条件式。値に予約文字が含まれる場合は引用符で囲む。
+func Cond(column, op, value string) string {
+	if strings.ContainsAny(value, `,.:()"\ `) {
+		value = quote(value)
+	}
+	return column + "." + op + "." + value
+}
+
+func (q *Query) Order(column string, ascending bool) *Query {
+	dir := ".desc"
+	if ascending {
+		dir = ".asc"
+	}
+	q.params.Set("order", column+dir)
+	return q
+}
+
+func (q *Query) Limit(n int) *Query {
+	q.params.Set("limit", strconv.Itoa(n))
+	return q
+}
+
+func (q *Query) Offset(n int) *Query {
+	q.params.Set("offset", strconv.Itoa(n))
+	return q
+}
+
+// OnConflict は upsert 時の衝突判定カラム
+func (q *Query) OnConflict(columns string) *Query {
+	q.params.Set("on_conflict", columns)
+	return q
+}
+
+func (q *Query) path() string {
+	p := "/rest/v1/" + url.PathEscape(q.table)
+	if len(q.params) > 0 {
+		p += "?" + q.params.Encode()
+	}
+	return p
+}
+
+/* =======================
+   実行
+======================= */
+
+func (q *Query) Get(ctx context.Context, out any) error {
+	return do(ctx, http.MethodGet, q.path(), nil, out)
+}
+
+// Count は条件に一致する行数
+func (q *Query) Count(ctx context.Context) (int, error) {
+	return count(ctx, q.path())
+}
+
+// Insert は行（または行の配列）を追加する。out が nil なら結果を返さない。
+func (q *Query) Insert(ctx context.Context, rows, out any) error {
+	return do(ctx, http.MethodPost, q.path(), rows, out)
+}
+
+// Upsert は OnConflict のカラムが一致する行を上書きする
+func (q *Query) Upsert(ctx context.Context, rows, out any) error {
+	return do(ctx, http.MethodPost, q.path(), rows, out, "resolution=merge-duplicates")
+}
+
+func (q *Query) Update(ctx context.Context, patch, out any) error {
+	return do(ctx, http.MethodPatch, q.path(), patch, out)
+}
+
+func (q *Query) Delete(ctx context.Context, out any) error {
+	return do(ctx, http.MethodDelete, q.path(), nil, out)
+}
+
+// リスト・論理式の中では , . : ( ) が区切りになるため "..." で囲む
+func quote(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `"`, `\"`)
+	return `"` + v + `"`
+}
@@ -0,0 +1,148 @@
+package supabase
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// path() のクエリ文字列をデコードする（PostgREST が受け取る値）
+func decodeQuery(t *testing.T, q *Query) url.Values {
+	t.Helper()
+	p := q.path()
+	_, raw, _ := strings.Cut(p, "?")
+	v, err := url.ParseQuery(raw)
+	if err != nil {
+		t.Fatalf("ParseQuery(%q): %v", raw, err)
+	}
+	return v
+}
+
+// PostgREST と同じ規則で in.(...) / or=(...) の中身を分ける（"..." の中の区切りと \ のエスケープを扱う）
+func splitList(t *testing.T, s string) []string {
+	t.Helper()
+	if !strings.HasPrefix(s, "(") || !strings.HasSuffix(s, ")") {
+		t.Fatalf("括弧で囲まれていません: %q", s)
+	}
+	s = s[1 : len(s)-1]
+	var items []string
+	var cur strings.Builder
+	inQuote := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case inQuote && c == '\\' && i+1 < len(s):
+			i++
+			cur.WriteByte(s[i])
+		case c == '"':
+			inQuote = !inQuote
+		case c == ',' && !inQuote:
+			items = append(items, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	if inQuote {
+		t.Fatalf("引用符が閉じていません: %q", s)
+	}
+	return append(items, cur.String())
+}
+
+var trickyValues = []string{
+	"plain",
+	`a,b`,
+	`a.b:c`,
+	`(a)`,
+	`say "hi"`,
+	`back\slash`,
+	`a&b=c`,
+	`with space`,
+	`#frag?x=1`,
+	`100%+1`,
+	`",eq.x)`,
+}
+
+// 値は 1 つのパラメータの中に収まり、他のパラメータを作らない
+func TestFilterEscapesValues(t *testing.T) {
+	for _, v := range trickyValues {
+		q := From("auth_codes").Select("code").Eq("batch", v).Limit(10)
+		got := decodeQuery(t, q)
+		want := url.Values{"select": {"code"}, "batch": {"eq." + v}, "limit": {"10"}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Eq(%q) → %v, want %v", v, got, want)
+		}
+	}
+
+	if p := From("a b/c").path(); p != "/rest/v1/a%20b%2Fc" {
+		t.Errorf("テーブル名 = %q", p)
+	}
+}
+
+func TestIn(t *testing.T) {
+	got := decodeQuery(t, From("users").In("line_user_id", trickyValues...))
+	if len(got) != 1 {
+		t.Fatalf("パラメータ = %v", got)
+	}
+	list := strings.TrimPrefix(got.Get("line_user_id"), "in.")
+	if items := splitList(t, list); !reflect.DeepEqual(items, trickyValues) {
+		t.Errorf("in の要素 = %q, want %q", items, trickyValues)
+	}
+}
+
+func TestCond(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"null", "expires_at.is.null"},
+		{"2026-10-01T00:00:00Z", `expires_at.is."2026-10-01T00:00:00Z"`},
+		{`a,b`, `expires_at.is."a,b"`},
+		{`say "hi"`, `expires_at.is."say \"hi\""`},
+		{`back\slash`, `expires_at.is."back\\slash"`},
+	}
+	for _, tt := range tests {
+		if got := Cond("expires_at", "is", tt.value); got != tt.want {
+			t.Errorf("Cond(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+
+	// Or の中でも値が区切りを越えない
+	var conds []string
+	for _, v := range trickyValues {
+		conds = append(conds, Cond("batch", "eq", v))
+	}
+	got := decodeQuery(t, From("auth_codes").Or(conds...))
+	items := splitList(t, got.Get("or"))
+	if len(items) != len(trickyValues) {
+		t.Fatalf("or の条件 %d 個, want %d: %q", len(items), len(trickyValues), items)
+	}
+	for i, item := range items {
+		if want := "batch.eq." + trickyValues[i]; item != want {
+			t.Errorf("or の条件 %d = %q, want %q", i, item, want)
+		}
+	}
+}
+
+// 検索語の % _ \ は文字そのもの、* だけがワイルドカード
+func TestUserFilterSearch(t *testing.T) {
+	tests := []struct {
+		search, want string
+	}{
+		{"U123", `ilike.*U123*`},
+		{"U1_3", `ilike.*U1\_3*`},
+		{"100%", `ilike.*100\%*`},
+		{`a\b`, `ilike.*a\\b*`},
+		{"U1*f", `ilike.*U1*f*`},
+		{"a&plan=eq.pro", `ilike.*a&plan=eq.pro*`},
+	}
+	for _, tt := range tests {
+		q, err := UserFilter{Search: tt.search}.query()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := decodeQuery(t, q)
+		if len(got) != 1 || got.Get("line_user_id") != tt.want {
+			t.Errorf("Search %q → %v, want line_user_id=%q", tt.search, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package supabase
+
+import (
+	"context"
+	"time"
+)
+
+// 利用枠の種類（consume_quota の p_kind）
+const (
+	QuotaGeneration = "generation"
+	QuotaChat       = "chat"
+)
+
+type QuotaResult struct {
+	Allowed bool `json:"allowed"`
+	Used    int  `json:"used"`
+	Quota   *int `json:"quota"` // nil は無制限
+}
+
+type QuotaUsage struct {
+	Plan             string `json:"plan"`
+	GenerationsUsed  int    `json:"generations_used"`
+	GenerationsQuota *int   `json:"generations_quota"`
+	ChatsUsed        int    `json:"chats_used"`
+	ChatsQuota       *int   `json:"chats_quota"`
+}
+
+var jst = time.FixedZone("JST", 9*60*60)
+
+// usage_daily.day と同じく日本時間の日付にする
+func periodDate(t time.Time) string {
+	return t.In(jst).Format("2006-01-02")
+}
+
+// periodStart 以降の利用が枠内なら1回分を記録する
+func ConsumeQuota(ctx context.Context, lineUserID, kind string, periodStart time.Time) (*QuotaResult, error) {
+	var rows []QuotaResult
+	err := RPC(ctx, "consume_quota",
+		map[string]string{
+			"p_line_user_id": lineUserID,
+			"p_kind":         kind,
+			"p_period_start": periodDate(periodStart),
+		},
+		&rows,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return &QuotaResult{}, nil
+	}
+	return &rows[0], nil
+}
+
+// 当日分から1回戻す
+func ReleaseQuota(ctx context.Context, lineUserID, kind string) error {
+	return RPC(ctx, "release_quota",
+		map[string]string{
+			"p_line_user_id": lineUserID,
+			"p_kind":         kind,
+		},
+		nil,
+	)
+}
+
+func GetQuotaUsage(ctx context.Context, lineUserID string, periodStart time.Time) (*QuotaUsage, error) {
+	var rows []QuotaUsage
+	err := RPC(ctx, "get_quota",
+		map[string]string{
+			"p_line_user_id": lineUserID,
+			"p_period_start": periodDate(periodStart),
+		},
+		&rows,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	return &rows[0], nil
+}
+
+// 日ごとの利用回数
+type UsageDay struct {
+	LineUserID  string `json:"line_user_id"`
+	Day         string `json:"day"` // YYYY-MM-DD（日本時間）
+	Generations int    `json:"generations"`
+	Chats       int    `json:"chats"`
+}
+
+// from〜to（両端を含む）の利用回数。lineUserID が空なら全ユーザー。
+func ListUsageDaily(ctx context.Context, lineUserID string, from, to time.Time) ([]UsageDay, error) {
+	q := From("usage_daily").
+		Select("line_user_id,day,generations,chats").
+		Gte("day", periodDate(from)).
+		Lte("day", periodDate(to)).
+		Order("day", true)
+	if lineUserID != "" {
+		q.Eq("line_user_id", lineUserID)
+	}
+
+	var days []UsageDay
+	if err := q.Get(ctx, &days); err != nil {
+		return nil, err
+	}
+	return days, nil
+}
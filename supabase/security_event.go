@@ -0,0 +1,56 @@
+package supabase
+
+import (
+	"context"
+	"time"
+)
+
+// 不審な操作の種類
+const (
+	EventLockout       = "auth_lockout"        // 失敗が続きロックした
+	EventLockedAttempt = "auth_locked_attempt" // ロック中に試行した
+	EventGlobalLimited = "auth_global_limited" // 全体のレート制限に達した
+
+	// 管理画面のログイン（line_user_id は空、接続元は detail.ip）
+	EventAdminLockout       = "admin_login_lockout"
+	EventAdminLockedAttempt = "admin_login_locked_attempt"
+	EventAdminGlobalLimited = "admin_login_global_limited"
+)
+
+type SecurityEvent struct {
+	ID         int64          `json:"id,omitempty"`
+	LineUserID string         `json:"line_user_id"`
+	Kind       string         `json:"kind"`
+	Detail     map[string]any `json:"detail,omitempty"`
+	CreatedAt  time.Time      `json:"created_at,omitempty"`
+}
+
+func AddSecurityEvent(ctx context.Context, ev SecurityEvent) error {
+	row := map[string]any{
+		"kind": ev.Kind,
+	}
+	if ev.LineUserID != "" {
+		row["line_user_id"] = ev.LineUserID
+	}
+	if ev.Detail != nil {
+		row["detail"] = ev.Detail
+	}
+	return From("security_events").Insert(ctx, row, nil)
+}
+
+// 新しい順に取得する。lineUserID が空なら全ユーザー。
+func ListSecurityEvents(ctx context.Context, lineUserID string, limit int) ([]SecurityEvent, error) {
+	q := From("security_events").
+		Select("id,line_user_id,kind,detail,created_at").
+		Order("created_at", false).
+		Limit(limit)
+	if lineUserID != "" {
+		q.Eq("line_user_id", lineUserID)
+	}
+
+	var events []SecurityEvent
+	if err := q.Get(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
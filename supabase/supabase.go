@@ -1,13 +1,9 @@
 package supabase
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
-	"log"
-	"net/http"
-	"os"
-	"strconv"
+	"time"
 )
 
 type Conversation struct {
@@ -15,63 +11,66 @@ type Conversation struct {
 }
 
 type User struct {
-	ID string `json:"id"`
+	ID            string     `json:"id"`
+	LineUserID    string     `json:"line_user_id"`
+	Plan          string     `json:"plan"`
+	ExpiresAt     *time.Time `json:"expires_at"`
+	RevokedAt     *time.Time `json:"revoked_at"`
+	RevokedReason *string    `json:"revoked_reason"`
+	CreatedAt     time.Time  `json:"created_at"`
 }
 
+const userColumns = "id,line_user_id,plan,expires_at,revoked_at,revoked_reason,created_at"
 
+// ユーザーの利用可否
+type UserStatus string
 
-var (
-	SUPABASE_URL = os.Getenv("SUPABASE_URL")
-	SUPABASE_SERVICE_ROLE_KEY=os.Getenv("SUPABASE_SERVICE_ROLE_KEY")
-	envErr= errors.New("SUPABASE_URL,SUPABASE_SERVICE_ROLE_KEYが見つかりません")
+const (
+	UserActive  UserStatus = "active"
+	UserExpired UserStatus = "expired"
+	UserRevoked UserStatus = "revoked"
 )
 
-func request(method, p string, body any) (*http.Response, error) {
-	if SUPABASE_URL == "" || SUPABASE_SERVICE_ROLE_KEY == "" {
-		return nil, envErr
+func (u *User) Status(now time.Time) UserStatus {
+	if u.RevokedAt != nil {
+		return UserRevoked
 	}
-
-	var buf bytes.Buffer
-	if body != nil {
-		if err := json.NewEncoder(&buf).Encode(body); err != nil {
-			return nil, err
-		}
+	if u.ExpiresAt != nil && !now.Before(*u.ExpiresAt) {
+		return UserExpired
 	}
+	return UserActive
+}
 
-	// path.Join は使わず、文字列連結で安全に
-	fullURL := SUPABASE_URL + p
+type Message struct {
+	Role      string    `json:"role"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
 
-	req, err := http.NewRequest(method, fullURL, &buf)
-	if err != nil {
-		return nil, err
-	}
 
-	req.Header.Set("apikey", SUPABASE_SERVICE_ROLE_KEY)
-	req.Header.Set("Authorization", "Bearer "+SUPABASE_SERVICE_ROLE_KEY)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Prefer", "return=minimal")
 
-	return http.DefaultClient.Do(req)
-}
+var userChangeHooks []func(lineUserID string)
 
+// ユーザーの登録・更新時に呼ばれる関数を登録する（キャッシュの破棄用）
+func OnUserChange(fn func(lineUserID string)) {
+	userChangeHooks = append(userChangeHooks, fn)
+}
 
-func GetUserByLineID(lineUserID string) (*User, error) {
-	resp, err := request(
-		"GET",
-		"/rest/v1/users?line_user_id=eq."+lineUserID+"&select=id",
-		nil,
-	)
-	if err != nil {
-		return nil, err
+func notifyUserChange(lineUserID string) {
+	for _, fn := range userChangeHooks {
+		fn(lineUserID)
 	}
-	defer resp.Body.Close()
+}
 
-	if resp.StatusCode != 200 {
-		return nil, errors.New("failed to fetch user")
-	}
 
+func GetUserByLineID(ctx context.Context, lineUserID string) (*User, error) {
 	var users []User
-	if err := json.NewDecoder(resp.Body).Decode(&users); err != nil {
+	err := From("users").
+		Select(userColumns).
+		Eq("line_user_id", lineUserID).
+		Limit(1).
+		Get(ctx, &users)
+	if err != nil {
 		return nil, err
 	}
 
@@ -83,53 +82,46 @@ func GetUserByLineID(lineUserID string) (*User, error) {
 }
 
 
-func AddMessage(conversationID, role, content string) error {
-	_, err := request(
-		"POST",
-		"/rest/v1/messages",
+func AddMessage(ctx context.Context, conversationID, role, content string) error {
+	return From("messages").Insert(ctx,
 		map[string]string{
 			"conversation_id": conversationID,
 			"role":            role,
 			"content":         content,
 		},
+		nil,
 	)
-	return err
 }
 
 
-func GetMessages(conversationID string, limit int) ([]map[string]string, error) {
-	resp, err := request(
-		"GET",
-		"/rest/v1/messages?conversation_id=eq."+conversationID+
-			"&order=created_at.asc&limit="+strconv.Itoa(limit),
-		nil,
-	)
+func GetMessages(ctx context.Context, conversationID string, limit int) ([]Message, error) {
+	var msgs []Message
+	err := From("messages").
+		Select("role,content,created_at").
+		Eq("conversation_id", conversationID).
+		Order("created_at", true).
+		Limit(limit).
+		Get(ctx, &msgs)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	var msgs []map[string]string
-	json.NewDecoder(resp.Body).Decode(&msgs)
 	return msgs, nil
 }
 
 
 
-func GetOrCreateConversation(userID, contextKey string) (string, error) {
+func GetOrCreateConversation(ctx context.Context, userID, contextKey string) (string, error) {
 	// ① 取得
-	resp, err := request(
-		"GET",
-		"/rest/v1/conversations?user_id=eq."+userID+"&context_key=eq."+contextKey,
-		nil,
-	)
+	var convs []Conversation
+	err := From("conversations").
+		Select("id").
+		Eq("user_id", userID).
+		Eq("context_key", contextKey).
+		Limit(1).
+		Get(ctx, &convs)
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
-
-	var convs []Conversation
-	json.NewDecoder(resp.Body).Decode(&convs)
 
 	if len(convs) > 0 {
 		return convs[0].ID, nil
@@ -137,72 +129,159 @@ func GetOrCreateConversation(userID, contextKey string) (string, error) {
 
 	// ② 作成
 	var created []Conversation
-	resp, err = request(
-		"POST",
-		"/rest/v1/conversations?select=id",
+	err = From("conversations").Select("id").Insert(ctx,
 		map[string]string{
 			"user_id":     userID,
 			"context_key": contextKey,
 		},
+		&created,
 	)
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
-
-	json.NewDecoder(resp.Body).Decode(&created)
+	if len(created) == 0 {
+		return "", errors.New("conversation の作成結果が空です")
+	}
 	return created[0].ID, nil
 }
 
 
 
-func IsUser(lineUserID string)(bool,error){
-	resp,err:=request(
-		"GET",
-		"/rest/v1/users?line_user_id=eq."+lineUserID,
+func IsUser(ctx context.Context, lineUserID string) (bool, error) {
+	user, err := GetUserByLineID(ctx, lineUserID)
+	if err != nil {
+		return false, err
+	}
+	return user != nil, nil
+}
+
+
+func AddUser(ctx context.Context, lineUserID string) error {
+	err := From("users").Insert(ctx,
+		map[string]string{
+			"line_user_id": lineUserID,
+		},
 		nil,
 	)
+	if err != nil {
+		return err
+	}
+	notifyUserChange(lineUserID)
+	return nil
+}
+
+
+// 即時に利用停止する
+func RevokeUser(ctx context.Context, lineUserID, reason string) (*User, error) {
+	patch := map[string]any{
+		"revoked_at":     time.Now().UTC(),
+		"revoked_reason": reason,
+	}
+	return updateUser(ctx, lineUserID, patch)
+}
+
 
-	if  err!=nil {
-		return false,err
+func updateUser(ctx context.Context, lineUserID string, patch map[string]any) (*User, error) {
+	var users []User
+	err := From("users").
+		Select(userColumns).
+		Eq("line_user_id", lineUserID).
+		Update(ctx, patch, &users)
+	if err != nil {
+		return nil, err
 	}
+	notifyUserChange(lineUserID)
+	if len(users) == 0 {
+		return nil, nil
+	}
+	return &users[0], nil
+}
 
-	defer resp.Body.Close()
 
-	var users []map[string]any
+// 利用停止を解除する
+func RestoreUser(ctx context.Context, lineUserID string) (*User, error) {
+	patch := map[string]any{
+		"revoked_at":     nil,
+		"revoked_reason": nil,
+	}
+	return updateUser(ctx, lineUserID, patch)
+}
+
 
-	json.NewDecoder(resp.Body).Decode(&users)
+type UserFilter struct {
+	Search string     // line_user_id の部分一致（* はワイルドカード）
+	Status UserStatus // 空なら全件
+	Plan   string
+	Limit  int
+	Offset int
+}
 
-	return len(users)>0,nil
+func (f UserFilter) query() (*Query, error) {
+	q := From("users")
+	if f.Search != "" {
+		q.ILike("line_user_id", "*"+likeEscape(f.Search)+"*")
+	}
+	if f.Plan != "" {
+		q.Eq("plan", f.Plan)
+	}
 
+	now := time.Now().UTC().Format(time.RFC3339)
+	switch f.Status {
+	case "":
+	case UserActive:
+		q.Is("revoked_at", "null").
+			Or(Cond("expires_at", "is", "null"), Cond("expires_at", "gt", now))
+	case UserExpired:
+		q.Is("revoked_at", "null").Lte("expires_at", now)
+	case UserRevoked:
+		q.Not("revoked_at", "is", "null")
+	default:
+		return nil, InputError("不明な状態です: " + string(f.Status))
+	}
+	return q, nil
 }
 
+// 登録の新しい順
+func ListUsers(ctx context.Context, f UserFilter) ([]User, error) {
+	q, err := f.query()
+	if err != nil {
+		return nil, err
+	}
+	q.Select(userColumns).Order("created_at", false)
+	if f.Limit > 0 {
+		q.Limit(f.Limit)
+	}
+	if f.Offset > 0 {
+		q.Offset(f.Offset)
+	}
 
-func AddUser(lineUserID string)error{
-	_,err:=request(
-		"POST",
-		"/rest/v1/users",
-		map[string]string{
-			"line_user_id": lineUserID,
-		},
-	)
-	return err
-}
-
-
-func UseAuthCode(code string) (bool, error) {
-    resp, err := request(
-        "PATCH", 
-        "/rest/v1/auth_codes?code=eq."+code+"&used=eq.false",
-        map[string]bool{"used": true},
-    )
-    if err != nil {
-        return false, err
-    }
-    defer resp.Body.Close()
-	log.Println("StatusCode:", resp.StatusCode)
-    if resp.StatusCode == 204 {
-        return true, nil
-    }
-    return false, nil
+	var users []User
+	if err := q.Get(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// 条件に一致するユーザー数（Limit / Offset は無視）
+func CountUsers(ctx context.Context, f UserFilter) (int, error) {
+	q, err := f.query()
+	if err != nil {
+		return 0, err
+	}
+	return q.Count(ctx)
+}
+
+func GetUsersByLineIDs(ctx context.Context, lineUserIDs []string) ([]User, error) {
+	if len(lineUserIDs) == 0 {
+		return nil, nil
+	}
+	var users []User
+	err := From("users").
+		Select(userColumns).
+		In("line_user_id", lineUserIDs...).
+		Get(ctx, &users)
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
 }
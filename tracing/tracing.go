@@ -0,0 +1,125 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"go_project/config"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/* =======================
+   OpenTelemetry トレース
+======================= */
+
+const tracerName = "go_project"
+
+// OTLP/HTTP エクスポーターを設定する。エンドポイントが空なら何も送らない。
+// 戻り値の関数で未送信のスパンを送り切って終了する。
+func Configure(ctx context.Context, c config.Tracing) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+
+	if c.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	// OTEL_EXPORTER_OTLP_ENDPOINT と同じく、ベースURLに /v1/traces を付ける
+	exp, err := otlptracehttp.New(ctx,
+		otlptracehttp.WithEndpointURL(strings.TrimRight(c.Endpoint, "/")+"/v1/traces"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(attribute.String("service.name", c.ServiceName)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.SampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// スパンを開始する（設定前・無効時は何も記録しない）
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// エラーがあれば記録してスパンを閉じる
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// 送信ヘッダーに traceparent を付ける
+func Inject(ctx context.Context, h http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(h))
+}
+
+// 外部APIクライアント用：HTTP 呼び出しごとに子スパンを作り、traceparent を付ける。
+// URL はそのまま記録されるので、クエリに個人情報を含む呼び出しには使わない。
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return otelhttp.NewTransport(base)
+}
+
+// RedactedTransport は Transport と同じだが、記録する URL とスパン名のパスを redact で書き換える。
+// LINE API のようにパスに個人情報（ユーザーID）を含む呼び出し用。
+func RedactedTransport(base http.RoundTripper, redact func(path string) string) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return otelhttp.NewTransport(redactedURL{base, redact},
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return "HTTP " + r.Method + " " + redact(r.URL.Path)
+		}),
+	)
+}
+
+// otelhttp が開始したスパンの URL 属性を上書きしてから送る
+type redactedURL struct {
+	base   http.RoundTripper
+	redact func(path string) string
+}
+
+func (t redactedURL) RoundTrip(r *http.Request) (*http.Response, error) {
+	if span := trace.SpanFromContext(r.Context()); span.IsRecording() {
+		u := *r.URL
+		u.User, u.RawQuery, u.Fragment = nil, "", ""
+		u.Path, u.RawPath = t.redact(u.Path), ""
+		// http.url は OTEL_SEMCONV_STABILITY_OPT_IN=http/dup のときの旧属性
+		span.SetAttributes(attribute.String("url.full", u.String()), attribute.String("http.url", u.String()))
+	}
+	return t.base.RoundTrip(r)
+}
+
+// 受信リクエストのサーバースパン
+func Handler(h http.Handler, operation string) http.Handler {
+	return otelhttp.NewHandler(h, operation)
+}
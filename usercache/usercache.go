@@ -0,0 +1,120 @@
+package usercache
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go_project/logging"
+	"go_project/supabase"
+)
+
+/* =======================
+   ユーザー情報キャッシュ
+======================= */
+
+var (
+	// 登録済みユーザーを再取得するまでの時間。
+	// CLI や DB で直接行った利用停止はこの間届かないので、利用枠を消費する前には Refresh で確かめる。
+	TTL = 5 * time.Minute
+	// 未登録（nil）を覚えておく時間
+	NegativeTTL = 30 * time.Second
+	// Supabase に繋がらないとき、登録済みユーザーの古い情報を使ってよい期間
+	StaleTTL = time.Hour
+)
+
+type entry struct {
+	user    *supabase.User
+	fetched time.Time
+}
+
+var (
+	mu      sync.Mutex
+	entries = map[string]entry{}
+	// Invalidate のたびに増やす。取得中に変わったら結果を保存しない
+	// （認証直後の Invalidate を、その前に始まった取得の「未登録」で上書きしないため）
+	invalidations uint64
+
+	// 現在時刻（テストで差し替える）
+	clock = time.Now
+)
+
+func init() {
+	// 同じプロセス内での登録・利用停止は即座に反映する
+	supabase.OnUserChange(Invalidate)
+}
+
+// キャッシュ経由でユーザーを取得する。未登録なら nil。
+func Get(ctx context.Context, lineUserID string) (*supabase.User, error) {
+	return get(ctx, lineUserID, false)
+}
+
+// Refresh はキャッシュを使わずに取得し直す（生成・会話など利用枠を消費する前の確認用）。
+// Supabase に繋がらないときは Get と同じく古い情報で続行する。
+func Refresh(ctx context.Context, lineUserID string) (*supabase.User, error) {
+	return get(ctx, lineUserID, true)
+}
+
+func get(ctx context.Context, lineUserID string, fresh bool) (*supabase.User, error) {
+	now := clock()
+
+	mu.Lock()
+	e, ok := entries[lineUserID]
+	gen := invalidations
+	mu.Unlock()
+
+	if ok && !fresh && now.Sub(e.fetched) < ttlFor(e) {
+		return e.user, nil
+	}
+
+	user, err := supabase.GetUserByLineID(ctx, lineUserID)
+	if err != nil {
+		// 短い障害で購入者を締め出さないよう、古い情報で続行する
+		if ok && e.user != nil && now.Sub(e.fetched) < StaleTTL {
+			slog.WarnContext(ctx, "ユーザー取得失敗のためキャッシュを使用します",
+				logging.User(lineUserID), "age", now.Sub(e.fetched).Round(time.Second).String(), logging.Err(err))
+			return e.user, nil
+		}
+		return nil, err
+	}
+
+	mu.Lock()
+	if invalidations == gen {
+		entries[lineUserID] = entry{user: user, fetched: now}
+		if len(entries) > maxEntries {
+			prune(now)
+		}
+	}
+	mu.Unlock()
+
+	return user, nil
+}
+
+// 登録・利用停止したユーザーのキャッシュを捨てる（その時点で取得中の結果も保存しない）
+func Invalidate(lineUserID string) {
+	mu.Lock()
+	delete(entries, lineUserID)
+	invalidations++
+	mu.Unlock()
+}
+
+func ttlFor(e entry) time.Duration {
+	if e.user == nil {
+		return NegativeTTL
+	}
+	return TTL
+}
+
+const maxEntries = 10000
+
+// 使えなくなったエントリを捨てる（mu を保持して呼ぶ）
+func prune(now time.Time) {
+	for id, e := range entries {
+		if e.user == nil && now.Sub(e.fetched) >= NegativeTTL {
+			delete(entries, id)
+		} else if now.Sub(e.fetched) >= StaleTTL {
+			delete(entries, id)
+		}
+	}
+}
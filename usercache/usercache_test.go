@@ -0,0 +1,190 @@
+package usercache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go_project/config"
+	"go_project/supabase"
+)
+
+// users テーブルの取得だけに答える Supabase
+type fakeSupabase struct {
+	mu      sync.Mutex
+	users   map[string]supabase.User
+	fail    bool
+	lookups int
+	// 設定すれば、取得を受け付けたことを知らせてから release まで応答を止める
+	started chan struct{}
+	release chan struct{}
+}
+
+func (f *fakeSupabase) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// 応答は受け付けた時点の内容で作る
+	f.mu.Lock()
+	f.lookups++
+	fail, started, release := f.fail, f.started, f.release
+	users := []supabase.User{}
+	if u, ok := f.users[strings.TrimPrefix(r.URL.Query().Get("line_user_id"), "eq.")]; ok {
+		users = append(users, u)
+	}
+	f.mu.Unlock()
+
+	if started != nil {
+		started <- struct{}{}
+		<-release
+	}
+	if fail {
+		http.Error(w, `{"message":"unavailable"}`, http.StatusServiceUnavailable)
+		return
+	}
+	json.NewEncoder(w).Encode(users)
+}
+
+func (f *fakeSupabase) set(fn func(f *fakeSupabase)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn(f)
+}
+
+func (f *fakeSupabase) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lookups
+}
+
+// fake の Supabase と進められる時計でキャッシュを空にして始める
+func setup(t *testing.T) (*fakeSupabase, func(time.Duration)) {
+	t.Helper()
+	fake := &fakeSupabase{users: map[string]supabase.User{}}
+	srv := httptest.NewServer(fake)
+	supabase.Configure(config.Supabase{URL: srv.URL, ServiceRoleKey: "test"})
+
+	now := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	clock = func() time.Time { return now }
+	mu.Lock()
+	entries = map[string]entry{}
+	mu.Unlock()
+
+	t.Cleanup(func() {
+		srv.Close()
+		supabase.Configure(config.Supabase{})
+		clock = time.Now
+	})
+	return fake, func(d time.Duration) { now = now.Add(d) }
+}
+
+func mustGet(t *testing.T, id string) *supabase.User {
+	t.Helper()
+	u, err := Get(context.Background(), id)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	return u
+}
+
+func TestTTL(t *testing.T) {
+	tests := []struct {
+		name       string
+		registered bool
+		ttl        time.Duration
+	}{
+		{"登録済み", true, TTL},
+		{"未登録", false, NegativeTTL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, advance := setup(t)
+			if tt.registered {
+				fake.users["U1"] = supabase.User{ID: "1", LineUserID: "U1"}
+			}
+
+			for i := 0; i < 2; i++ {
+				if u := mustGet(t, "U1"); (u != nil) != tt.registered {
+					t.Fatalf("Get = %v, want 登録済み %v", u, tt.registered)
+				}
+			}
+			if n := fake.count(); n != 1 {
+				t.Fatalf("取得 %d 回, want 1（2 回目はキャッシュ）", n)
+			}
+
+			advance(tt.ttl - time.Second)
+			mustGet(t, "U1")
+			if n := fake.count(); n != 1 {
+				t.Fatalf("期限前に取得し直しました（%d 回）", n)
+			}
+			advance(time.Second)
+			mustGet(t, "U1")
+			if n := fake.count(); n != 2 {
+				t.Fatalf("期限後の取得 %d 回, want 2", n)
+			}
+
+			// Refresh はキャッシュを使わない
+			if _, err := Refresh(context.Background(), "U1"); err != nil {
+				t.Fatal(err)
+			}
+			if n := fake.count(); n != 3 {
+				t.Errorf("Refresh で取得していません（%d 回）", n)
+			}
+		})
+	}
+}
+
+func TestStaleOnError(t *testing.T) {
+	fake, advance := setup(t)
+	fake.users["U1"] = supabase.User{ID: "1", LineUserID: "U1"}
+	mustGet(t, "U1")
+	mustGet(t, "U2") // 未登録
+
+	fake.set(func(f *fakeSupabase) { f.fail = true })
+	advance(TTL)
+	if u := mustGet(t, "U1"); u == nil || u.ID != "1" {
+		t.Fatalf("取得に失敗したときに古い情報を使っていません: %v", u)
+	}
+	if _, err := Get(context.Background(), "U2"); err == nil {
+		t.Error("未登録の古い情報で続行しました")
+	}
+
+	advance(StaleTTL - TTL)
+	if _, err := Get(context.Background(), "U1"); err == nil {
+		t.Error("StaleTTL を過ぎた古い情報で続行しました")
+	}
+}
+
+// 取得中に Invalidate されたら、その取得の結果は保存しない
+func TestInvalidateDuringFetch(t *testing.T) {
+	fake, _ := setup(t)
+	started, release := make(chan struct{}), make(chan struct{})
+	fake.set(func(f *fakeSupabase) { f.started, f.release = started, release })
+
+	done := make(chan *supabase.User)
+	go func() {
+		u, _ := Get(context.Background(), "U1")
+		done <- u
+	}()
+	<-started
+
+	// 取得中に認証コードを使って登録された
+	fake.set(func(f *fakeSupabase) {
+		f.users["U1"] = supabase.User{ID: "1", LineUserID: "U1"}
+		f.started, f.release = nil, nil
+	})
+	Invalidate("U1")
+	close(release)
+	if u := <-done; u != nil {
+		t.Fatalf("取得中の結果 = %v, want nil（登録前に取得した）", u)
+	}
+
+	if u := mustGet(t, "U1"); u == nil {
+		t.Fatal("登録前の「未登録」がキャッシュに残りました")
+	}
+	if n := fake.count(); n != 2 {
+		t.Errorf("取得 %d 回, want 2", n)
+	}
+}